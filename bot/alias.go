@@ -0,0 +1,216 @@
+package bot
+
+import (
+	"autodl_bot/client"
+	"autodl_bot/models"
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	maxAliasLength = 32
+	// maxPickButtons 限制候选列表中的按钮数量，过多时需要输入更长的前缀
+	maxPickButtons = 10
+)
+
+// fullUUID 匹配完整的AutoDL实例UUID，例如 3d2b11a3cf-2c2f5d5a
+var fullUUID = regexp.MustCompile(`^[0-9a-f]{10}-[0-9a-f]{8}$`)
+
+// aliasCommand 设置、删除或列出实例别名
+func (b *Bot) aliasCommand(ctx context.Context, msg *tgbotapi.Message) string {
+	userID := int(msg.From.ID)
+	aliases, err := b.storage.ListAliases(userID)
+	if err != nil {
+		log.Printf("[ERROR] 读取实例别名失败: %v", err)
+		return "读取别名失败，请稍后重试"
+	}
+
+	args := strings.Fields(msg.CommandArguments())
+	switch len(args) {
+	case 0:
+		return aliasList(aliases)
+	case 2:
+	default:
+		return "用法：/alias <uuid> <别名>，删除别名：/alias <uuid|别名> off"
+	}
+
+	autodl, err := b.autodlClient(userID)
+	if err != nil {
+		return errorReply(err)
+	}
+	name := args[1]
+	if name == "off" {
+		// 实例释放后也可以删除别名，不要求实例存在
+		uuid, ok := b.instanceArg(ctx, msg, autodl, args[0], "")
+		if !ok {
+			return ""
+		}
+		n, err := b.storage.DeleteAlias(userID, uuid)
+		if err != nil {
+			log.Printf("[ERROR] 删除实例别名失败: %v", err)
+			return "删除别名失败，请稍后重试"
+		}
+		if n == 0 {
+			return fmt.Sprintf("实例 %s 没有设置别名", uuid)
+		}
+		return fmt.Sprintf("已删除实例 %s 的别名", uuid)
+	}
+	if utf8.RuneCountInString(name) > maxAliasLength {
+		return fmt.Sprintf("别名不能超过%d个字符", maxAliasLength)
+	}
+	uuid, reply := b.existingInstanceArg(ctx, msg, autodl, args[0])
+	if uuid == "" {
+		return reply
+	}
+	for other, otherName := range aliases {
+		if otherName == name && other != uuid {
+			return fmt.Sprintf("别名 %s 已被实例 %s 使用", name, other)
+		}
+	}
+	if err := b.storage.SetAlias(userID, uuid, name); err != nil {
+		log.Printf("[ERROR] 保存实例别名失败: %v", err)
+		return "保存别名失败，请稍后重试"
+	}
+	return fmt.Sprintf("已将实例 %s 的别名设置为 %s，之后可以用别名代替UUID，例如：/start %s", uuid, name, name)
+}
+
+func aliasList(aliases map[string]string) string {
+	if len(aliases) == 0 {
+		return "还没有设置实例别名，使用 /alias <uuid> <别名> 设置"
+	}
+	uuids := make([]string, 0, len(aliases))
+	for uuid := range aliases {
+		uuids = append(uuids, uuid)
+	}
+	sort.Slice(uuids, func(i, j int) bool { return aliases[uuids[i]] < aliases[uuids[j]] })
+
+	var sb strings.Builder
+	sb.WriteString("实例别名：")
+	for _, uuid := range uuids {
+		sb.WriteString(fmt.Sprintf("\n%s - %s", aliases[uuid], uuid))
+	}
+	return sb.String()
+}
+
+// aliases 读取用户的实例别名，出错时返回空映射，不影响命令执行
+func (b *Bot) aliases(userID int) map[string]string {
+	aliases, err := b.storage.ListAliases(userID)
+	if err != nil {
+		log.Printf("[ERROR] 读取实例别名失败: %v", err)
+		return map[string]string{}
+	}
+	return aliases
+}
+
+// instanceArg 把命令中的别名或UUID前缀解析为完整UUID，完整的UUID不查询实例列表直接返回。
+// 前缀匹配到多个实例时发送候选列表并返回false，action不为空时候选列表带有执行该操作的按钮。
+// 获取实例列表失败或没有匹配的实例时原样返回，由AutoDL接口报告错误。
+func (b *Bot) instanceArg(ctx context.Context, msg *tgbotapi.Message, autodl *client.AutoDLClient, target, action string) (string, bool) {
+	userID := int(msg.From.ID)
+	aliases := b.aliases(userID)
+	if uuid, ok := aliasTarget(aliases, target); ok {
+		return uuid, true
+	}
+	if fullUUID.MatchString(target) {
+		return target, true
+	}
+
+	instances, err := autodl.ListAllInstancesContext(ctx, client.InstanceFilter{})
+	if err != nil {
+		log.Printf("[ERROR] 获取用户%d的实例列表失败，无法解析 %s: %v", userID, target, err)
+		return target, true
+	}
+	matches := matchInstances(target, instances)
+	switch len(matches) {
+	case 0:
+		return target, true
+	case 1:
+		return matches[0].UUID, true
+	}
+	b.sendPickList(msg, target, matches, aliases, action)
+	return "", false
+}
+
+// existingInstanceArg 与 instanceArg 一样解析别名或UUID前缀，但要求匹配到账号下的实例。
+// 解析失败时返回空UUID和回复文本，匹配到多个实例时已发送候选列表，回复文本为空
+func (b *Bot) existingInstanceArg(ctx context.Context, msg *tgbotapi.Message, autodl *client.AutoDLClient, target string) (string, string) {
+	aliases := b.aliases(int(msg.From.ID))
+	if uuid, ok := aliasTarget(aliases, target); ok {
+		target = uuid
+	}
+	instances, err := autodl.ListAllInstancesContext(ctx, client.InstanceFilter{})
+	if err != nil {
+		return "", "获取实例列表失败：" + errorReply(err)
+	}
+	matches := matchInstances(target, instances)
+	switch len(matches) {
+	case 0:
+		return "", fmt.Sprintf("没有找到实例 %s，请使用 /gpuvalid 查看实例UUID", target)
+	case 1:
+		return matches[0].UUID, ""
+	}
+	b.sendPickList(msg, target, matches, aliases, "")
+	return "", ""
+}
+
+// aliasTarget 返回别名对应的UUID
+func aliasTarget(aliases map[string]string, target string) (string, bool) {
+	for uuid, name := range aliases {
+		if name == target {
+			return uuid, true
+		}
+	}
+	return "", false
+}
+
+// matchInstances 返回UUID与target完全相同的实例，没有时返回UUID以target开头的所有实例
+func matchInstances(target string, instances []models.Instance) []models.Instance {
+	var matches []models.Instance
+	for _, instance := range instances {
+		if instance.UUID == target {
+			return []models.Instance{instance}
+		}
+		if strings.HasPrefix(instance.UUID, target) {
+			matches = append(matches, instance)
+		}
+	}
+	return matches
+}
+
+// sendPickList 发送匹配到的候选实例，点击按钮直接对选中的实例执行操作
+func (b *Bot) sendPickList(msg *tgbotapi.Message, target string, matches []models.Instance, aliases map[string]string, action string) {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("有%d个实例以 %s 开头：", len(matches), target))
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, instance := range matches {
+		label := instance.UUID
+		if alias := aliases[instance.UUID]; alias != "" {
+			label += "（" + alias + "）"
+		}
+		sb.WriteString(fmt.Sprintf("\n%s %s-%s %s", label, instance.RegionName, instance.MachineAlias, client.StatusName(instance.Status)))
+		if action != "" && len(rows) < maxPickButtons {
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(label, b.callbackData(msg.From.ID, action, instance.UUID))))
+		}
+	}
+	if len(rows) > 0 {
+		sb.WriteString("\n请点击要操作的实例")
+	} else {
+		sb.WriteString("\n请使用更长的UUID前缀或别名重新执行")
+	}
+
+	reply := tgbotapi.NewMessage(msg.Chat.ID, sb.String())
+	if len(rows) > 0 {
+		reply.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	}
+	if _, err := b.api.Send(reply); err != nil {
+		log.Printf("error sending message: %v", err)
+	}
+}
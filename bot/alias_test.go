@@ -0,0 +1,103 @@
+package bot
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"autodl_bot/client"
+	"autodl_bot/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchInstances(t *testing.T) {
+	instances := []models.Instance{
+		{UUID: "3d2b11a3cf-2c2f5d5a"},
+		{UUID: "3d2b11a3cf-2c2f5d5a0"},
+		{UUID: "3d9c4e21aa-81f0b2c3"},
+		{UUID: "7f01d5e8b2-0c4d6e1f"},
+	}
+	uuids := func(target string) []string {
+		var result []string
+		for _, instance := range matchInstances(target, instances) {
+			result = append(result, instance.UUID)
+		}
+		return result
+	}
+
+	assert.Equal(t, []string{"7f01d5e8b2-0c4d6e1f"}, uuids("7f"))
+	assert.Equal(t, []string{"3d2b11a3cf-2c2f5d5a", "3d2b11a3cf-2c2f5d5a0", "3d9c4e21aa-81f0b2c3"}, uuids("3d"))
+	assert.Equal(t, []string{"3d2b11a3cf-2c2f5d5a"}, uuids("3d2b11a3cf-2c2f5d5a"), "完全相同的UUID优先于前缀匹配")
+	assert.Empty(t, uuids("ff"))
+}
+
+func TestAliasList(t *testing.T) {
+	assert.Contains(t, aliasList(nil), "还没有设置实例别名")
+	assert.Equal(t, "实例别名：\n推理 - 7f01d5e8b2-0c4d6e1f\n训练 - 3d2b11a3cf-2c2f5d5a",
+		aliasList(map[string]string{"3d2b11a3cf-2c2f5d5a": "训练", "7f01d5e8b2-0c4d6e1f": "推理"}))
+}
+
+func TestAliasCommandRequiresInstance(t *testing.T) {
+	b, telegram := newTestBot(t)
+	api := &fakeInstanceAPI{instances: []models.Instance{
+		{UUID: "3d2b11a3cf-2c2f5d5a"},
+		{UUID: "3d9c4e21aa-81f0b2c3"},
+		{UUID: "7f01d5e8b2-0c4d6e1f"},
+	}}
+	useClient(b, 1, newTestClient(t, api))
+	alias := func(args string) string {
+		return b.aliasCommand(context.Background(), commandMessage("/alias "+args))
+	}
+
+	assert.Equal(t, "没有找到实例 ff，请使用 /gpuvalid 查看实例UUID", alias("ff 训练"))
+	assert.Contains(t, alias("0000000000-00000000 训练"), "没有找到实例 0000000000-00000000")
+	assert.Empty(t, b.aliases(1))
+
+	assert.Empty(t, alias("3d 训练"), "匹配到多个实例时发送候选列表")
+	require.Len(t, telegram.sent(1), 1)
+	assert.Contains(t, telegram.sent(1)[0], "有2个实例以 3d 开头")
+
+	assert.Contains(t, alias("7f 推理"), "已将实例 7f01d5e8b2-0c4d6e1f 的别名设置为 推理")
+	assert.Contains(t, alias("推理 部署"), "已将实例 7f01d5e8b2-0c4d6e1f 的别名设置为 部署")
+	assert.Equal(t, map[string]string{"7f01d5e8b2-0c4d6e1f": "部署"}, b.aliases(1))
+
+	// 实例已释放时仍可删除别名
+	api.mu.Lock()
+	api.instances = nil
+	api.mu.Unlock()
+	assert.Equal(t, "已删除实例 7f01d5e8b2-0c4d6e1f 的别名", alias("部署 off"))
+}
+
+func TestAliasCommandListFailure(t *testing.T) {
+	b, _ := newTestBot(t)
+	useClient(b, 1, newTestClient(t, http.NotFoundHandler()))
+	reply := b.aliasCommand(context.Background(), commandMessage("/alias 7f 推理"))
+	assert.Contains(t, reply, "获取实例列表失败")
+	assert.Empty(t, b.aliases(1))
+}
+
+func TestInstanceArgSkipsLookupForFullUUID(t *testing.T) {
+	b, _ := newTestBot(t)
+	var listed atomic.Int32
+	api := &fakeInstanceAPI{instances: []models.Instance{{UUID: "7f01d5e8b2-0c4d6e1f"}}}
+	autodl := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == client.InstancePath {
+			listed.Add(1)
+		}
+		api.ServeHTTP(w, r)
+	}))
+	msg := commandMessage("/stop")
+
+	uuid, ok := b.instanceArg(context.Background(), msg, autodl, "0000000000-00000000", actionStopInstance)
+	assert.True(t, ok)
+	assert.Equal(t, "0000000000-00000000", uuid)
+	assert.Zero(t, listed.Load(), "完整的UUID不查询实例列表")
+
+	uuid, ok = b.instanceArg(context.Background(), msg, autodl, "7f", actionStopInstance)
+	assert.True(t, ok)
+	assert.Equal(t, "7f01d5e8b2-0c4d6e1f", uuid)
+	assert.EqualValues(t, 1, listed.Load())
+}
@@ -0,0 +1,88 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// parseFlags 把命令参数拆成位置参数和 --name value 形式的选项，同一选项只能出现一次。
+// 含空格的取值用引号括起来，例如 --until "2024-12-01 23:30"
+func parseFlags(args string, allowed ...string) ([]string, map[string]string, error) {
+	var positional []string
+	flags := make(map[string]string)
+
+	fields, err := splitArgs(args)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+		if !strings.HasPrefix(field, "--") {
+			positional = append(positional, field)
+			continue
+		}
+
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(field, "--"), "=")
+		if !containsString(allowed, name) {
+			return nil, nil, fmt.Errorf("不支持的选项：--%s", name)
+		}
+		if _, exist := flags[name]; exist {
+			return nil, nil, fmt.Errorf("选项 --%s 重复", name)
+		}
+		if !hasValue {
+			if i+1 >= len(fields) || strings.HasPrefix(fields[i+1], "--") {
+				return nil, nil, fmt.Errorf("选项 --%s 缺少取值", name)
+			}
+			i++
+			value = fields[i]
+		}
+		flags[name] = value
+	}
+	return positional, flags, nil
+}
+
+// splitArgs 按空白拆分参数，单引号或双引号括起来的部分作为一个参数的一部分
+func splitArgs(args string) ([]string, error) {
+	var fields []string
+	var field strings.Builder
+	inField := false
+	var quote rune
+	for _, r := range args {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				field.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote, inField = r, true
+		case unicode.IsSpace(r):
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteRune(r)
+			inField = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("引号不匹配：%s", args)
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return fields, nil
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package bot
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFlags(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       string
+		positional []string
+		flags      map[string]string
+		err        string
+	}{
+		{name: "空参数", args: "", flags: map[string]string{}},
+		{name: "只有位置参数", args: "a  b", positional: []string{"a", "b"}, flags: map[string]string{}},
+		{name: "空格分隔取值", args: "xx-yy --gpus 2", positional: []string{"xx-yy"}, flags: map[string]string{"gpus": "2"}},
+		{name: "等号取值", args: "--gpus=2 xx-yy", positional: []string{"xx-yy"}, flags: map[string]string{"gpus": "2"}},
+		{name: "等号后为空", args: "--gpus= xx-yy", positional: []string{"xx-yy"}, flags: map[string]string{"gpus": ""}},
+		{name: "多个选项", args: "xx-yy --gpus 2 --timeout=6h", positional: []string{"xx-yy"},
+			flags: map[string]string{"gpus": "2", "timeout": "6h"}},
+		{name: "双引号括起的取值", args: `xx-yy --until "2024-12-01 23:30"`, positional: []string{"xx-yy"},
+			flags: map[string]string{"until": "2024-12-01 23:30"}},
+		{name: "单引号和等号", args: `--until='2024-12-01 23:30' xx-yy`, positional: []string{"xx-yy"},
+			flags: map[string]string{"until": "2024-12-01 23:30"}},
+		{name: "引号不匹配", args: `--until "2024-12-01 23:30`, err: `引号不匹配：--until "2024-12-01 23:30`},
+		{name: "重复选项", args: "--gpus 2 --gpus=3", err: "选项 --gpus 重复"},
+		{name: "未知选项", args: "xx-yy --cpu 1", err: "不支持的选项：--cpu"},
+		{name: "只有前缀", args: "--", err: "不支持的选项：--"},
+		{name: "末尾缺少取值", args: "xx-yy --gpus", err: "选项 --gpus 缺少取值"},
+		{name: "取值是另一个选项", args: "--gpus --timeout 6h", err: "选项 --gpus 缺少取值"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			positional, flags, err := parseFlags(tt.args, "gpus", "timeout", "until")
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.positional, positional)
+			assert.Equal(t, tt.flags, flags)
+		})
+	}
+}
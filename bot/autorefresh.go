@@ -0,0 +1,164 @@
+package bot
+
+import (
+	"autodl_bot/client"
+	"autodl_bot/models"
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	autoRefreshInterval    = time.Hour
+	defaultRefreshDaysLeft = 3
+)
+
+// autoRefreshCommand 处理 /autorefresh <uuid> [释放前天数|off]，不带参数时列出策略
+func (b *Bot) autoRefreshCommand(msg *tgbotapi.Message) string {
+	userID := int(msg.From.ID)
+	args := strings.Fields(msg.CommandArguments())
+	if len(args) == 0 {
+		return b.listRefreshPolicies(userID)
+	}
+	if len(args) > 2 {
+		return "用法：/autorefresh xx-yy [释放前天数|off]"
+	}
+	uuid := args[0]
+
+	if len(args) == 2 && args[1] == "off" {
+		n, err := b.storage.DeleteRefreshPolicy(userID, uuid)
+		if err != nil {
+			log.Printf("[ERROR] 删除自动刷新策略失败: %v", err)
+			return "关闭自动刷新失败，请稍后重试"
+		}
+		if n == 0 {
+			return fmt.Sprintf("实例 %s 没有开启自动刷新", uuid)
+		}
+		return fmt.Sprintf("已关闭实例 %s 的自动刷新", uuid)
+	}
+
+	if _, err := b.autodlClient(userID); err != nil {
+		return errorReply(err)
+	}
+	days := defaultRefreshDaysLeft
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 || n >= int(client.ReleaseAfter/(24*time.Hour)) {
+			return fmt.Sprintf("释放前天数必须是1到%d之间的整数", int(client.ReleaseAfter/(24*time.Hour))-1)
+		}
+		days = n
+	}
+
+	policy := &models.RefreshPolicy{
+		TelegramID: userID,
+		ChatID:     msg.Chat.ID,
+		UUID:       uuid,
+		DaysBefore: days,
+	}
+	if err := b.storage.SaveRefreshPolicy(policy); err != nil {
+		log.Printf("[ERROR] 保存自动刷新策略失败: %v", err)
+		return "开启自动刷新失败，请稍后重试"
+	}
+	return fmt.Sprintf("已开启自动刷新：实例 %s 将在释放前%d天自动刷新释放时长", uuid, days)
+}
+
+func (b *Bot) listRefreshPolicies(userID int) string {
+	policies, err := b.storage.ListRefreshPolicies(userID)
+	if err != nil {
+		log.Printf("[ERROR] 读取自动刷新策略失败: %v", err)
+		return "读取自动刷新策略失败，请稍后重试"
+	}
+	if len(policies) == 0 {
+		return "当前没有开启自动刷新的实例，使用 /autorefresh xx-yy 3 开启"
+	}
+	reply := "自动刷新策略：\n"
+	for _, policy := range policies {
+		reply += fmt.Sprintf("%s 释放前%d天刷新\n", policy.UUID, policy.DaysBefore)
+	}
+	return reply
+}
+
+// runAutoRefresher 定期检查自动刷新策略，直到stop关闭
+func (b *Bot) runAutoRefresher(stop <-chan struct{}) {
+	ticker := time.NewTicker(autoRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.checkRefreshPolicies()
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (b *Bot) checkRefreshPolicies() {
+	policies, err := b.storage.ListRefreshPolicies(0)
+	if err != nil {
+		log.Printf("[ERROR] 读取自动刷新策略失败: %v", err)
+		return
+	}
+
+	byUser := make(map[int][]*models.RefreshPolicy)
+	for _, policy := range policies {
+		byUser[policy.TelegramID] = append(byUser[policy.TelegramID], policy)
+	}
+
+	for userID, userPolicies := range byUser {
+		autodl, err := b.backgroundClient(userID)
+		if err != nil {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+		instances, err := autodl.GetInstancesContext(ctx)
+		cancel()
+		if err != nil {
+			log.Printf("[ERROR] 用户%d的自动刷新查询实例失败: %v", userID, err)
+			continue
+		}
+
+		for _, policy := range userPolicies {
+			releaseAt, ok := refreshDue(findInstance(instances, policy.UUID), policy.DaysBefore, time.Now())
+			if !ok {
+				continue
+			}
+			if b.hasPendingJob(userID, jobRefresh, policy.UUID) {
+				continue
+			}
+			id, err := b.scheduleRefresh(userID, policy.ChatID, policy.UUID, false)
+			if err != nil {
+				log.Printf("[ERROR] 创建实例 %s 的自动刷新任务失败: %v", policy.UUID, err)
+				continue
+			}
+			b.sendText(policy.ChatID, fmt.Sprintf("实例 %s 将在 %s 释放，已创建自动刷新任务 #%d",
+				policy.UUID, releaseAt.Format("2006-01-02 15:04"), id))
+		}
+	}
+}
+
+// refreshDue 判断关机中的实例是否已进入释放前daysBefore天内，返回释放时间。
+// 实例不存在、未关机或关机时间无法解析时不刷新
+func refreshDue(instance *models.Instance, daysBefore int, now time.Time) (time.Time, bool) {
+	if instance == nil || instance.Status != models.StatusShutdown {
+		return time.Time{}, false
+	}
+	releaseAt, ok := client.ReleaseTime(*instance)
+	if !ok || releaseAt.Sub(now) > time.Duration(daysBefore)*24*time.Hour {
+		return time.Time{}, false
+	}
+	return releaseAt, true
+}
+
+func findInstance(instances []models.Instance, uuid string) *models.Instance {
+	for i := range instances {
+		if instances[i].UUID == uuid {
+			return &instances[i]
+		}
+	}
+	return nil
+}
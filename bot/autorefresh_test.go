@@ -0,0 +1,92 @@
+package bot
+
+import (
+	"testing"
+	"time"
+
+	"autodl_bot/client"
+	"autodl_bot/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func stoppedInstance(uuid string, stoppedAt time.Time) models.Instance {
+	return models.Instance{
+		UUID:      uuid,
+		Status:    models.StatusShutdown,
+		StoppedAt: models.NullTime{Time: stoppedAt.Format(time.RFC3339), Valid: true},
+	}
+}
+
+func TestRefreshDue(t *testing.T) {
+	now := time.Date(2024, 12, 1, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	// 距离释放还剩d时的关机时间
+	stoppedFor := func(d time.Duration) time.Time { return now.Add(d - client.ReleaseAfter) }
+	utc8 := time.FixedZone("UTC+8", 8*3600)
+
+	tests := []struct {
+		name     string
+		instance *models.Instance
+		days     int
+		due      bool
+	}{
+		{name: "实例不存在", instance: nil, days: 3},
+		{name: "远未到释放时间", instance: ptr(stoppedInstance("a", stoppedFor(10*day))), days: 3},
+		{name: "刚好超过阈值", instance: ptr(stoppedInstance("a", stoppedFor(3*day+time.Minute))), days: 3},
+		{name: "刚好等于阈值", instance: ptr(stoppedInstance("a", stoppedFor(3*day))), days: 3, due: true},
+		{name: "阈值以内", instance: ptr(stoppedInstance("a", stoppedFor(2*day))), days: 3, due: true},
+		{name: "阈值为1天", instance: ptr(stoppedInstance("a", stoppedFor(day+time.Hour))), days: 1},
+		{name: "已过释放时间", instance: ptr(stoppedInstance("a", stoppedFor(-time.Hour))), days: 3, due: true},
+		{name: "带时区的关机时间", instance: ptr(stoppedInstance("a", stoppedFor(3*day-time.Minute).In(utc8))), days: 3, due: true},
+		{name: "实例运行中", instance: &models.Instance{UUID: "a", Status: models.StatusRunning,
+			StoppedAt: models.NullTime{Time: stoppedFor(time.Hour).Format(time.RFC3339), Valid: true}}, days: 3},
+		{name: "关机时间不是RFC3339", instance: &models.Instance{UUID: "a", Status: models.StatusShutdown,
+			StoppedAt: models.NullTime{Time: stoppedFor(time.Hour).Format("2006-01-02 15:04:05"), Valid: true}}, days: 3},
+		{name: "没有关机时间", instance: &models.Instance{UUID: "a", Status: models.StatusShutdown}, days: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			releaseAt, due := refreshDue(tt.instance, tt.days, now)
+			assert.Equal(t, tt.due, due)
+			if due {
+				stoppedAt, err := time.Parse(time.RFC3339, tt.instance.StoppedAt.Time)
+				require.NoError(t, err)
+				assert.True(t, releaseAt.Equal(stoppedAt.Add(client.ReleaseAfter)))
+			}
+		})
+	}
+}
+
+func TestCheckRefreshPolicies(t *testing.T) {
+	b, telegram := newTestBot(t)
+	now := time.Now()
+	api := &fakeInstanceAPI{instances: []models.Instance{
+		stoppedInstance("due", now.Add(2*24*time.Hour-client.ReleaseAfter)),
+		stoppedInstance("later", now.Add(5*24*time.Hour-client.ReleaseAfter)),
+	}}
+	useClient(b, 1, newTestClient(t, api))
+	for _, uuid := range []string{"due", "later", "missing"} {
+		require.NoError(t, b.storage.SaveRefreshPolicy(&models.RefreshPolicy{
+			TelegramID: 1, ChatID: 10, UUID: uuid, DaysBefore: 3,
+		}))
+	}
+
+	b.checkRefreshPolicies()
+	b.checkRefreshPolicies()
+
+	jobs, err := b.scheduler.List(1)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1, "每个实例只创建一个刷新任务")
+	assert.Equal(t, jobRefresh, jobs[0].Kind)
+	assert.Equal(t, "due", jobs[0].UUID)
+	assert.Equal(t, refreshPending, jobs[0].Payload)
+	sent := telegram.sent(10)
+	require.Len(t, sent, 1)
+	assert.Contains(t, sent[0], "实例 due 将在")
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
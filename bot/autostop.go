@@ -0,0 +1,257 @@
+package bot
+
+import (
+	"autodl_bot/models"
+	"autodl_bot/scheduler"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// stopWarnBefore 是自动关机前发送提醒的提前量
+	stopWarnBefore = 10 * time.Minute
+	stopExtendStep = time.Hour
+	// payloadDeadline 标记按截止时间自动关机的 power_off 任务
+	payloadDeadline = "deadline"
+)
+
+// parseStopTime 解析关机时间，支持时长（3h、90m）、当天时刻（23:30，已过则为次日）和完整时间
+// （2024-12-01 23:30 或 2024-12-01T23:30，后者可直接用于命令选项）
+func parseStopTime(value string, loc *time.Location) (time.Time, error) {
+	now := time.Now().In(loc)
+	if d, err := time.ParseDuration(value); err == nil {
+		if d <= 0 {
+			return time.Time{}, fmt.Errorf("时长必须大于0")
+		}
+		return now.Add(d), nil
+	}
+	if t, err := time.ParseInLocation("15:04", value, loc); err == nil {
+		deadline := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, loc)
+		if !deadline.After(now) {
+			deadline = deadline.AddDate(0, 0, 1)
+		}
+		return deadline, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04", "2006-01-02T15:04"} {
+		deadline, err := time.ParseInLocation(layout, value, loc)
+		if err != nil {
+			continue
+		}
+		if !deadline.After(now) {
+			return time.Time{}, fmt.Errorf("关机时间已过")
+		}
+		return deadline, nil
+	}
+	return time.Time{}, fmt.Errorf("时间格式错误，例如：3h、90m、23:30、2024-12-01T23:30")
+}
+
+// startDeadline 解析 /start 的 --for 和 --until 选项，都未设置时返回零值
+func startDeadline(flags map[string]string, loc *time.Location) (time.Time, error) {
+	if value, ok := flags["for"]; ok {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return time.Time{}, fmt.Errorf("--for 格式错误，例如：30m、3h")
+		}
+		return time.Now().Add(d), nil
+	}
+	if value, ok := flags["until"]; ok {
+		deadline, err := parseStopTime(value, loc)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("--until %v", err)
+		}
+		return deadline, nil
+	}
+	return time.Time{}, nil
+}
+
+// stopAtCommand 处理 /stopat <uuid> <时长|时间>，到时自动关机
+func (b *Bot) stopAtCommand(msg *tgbotapi.Message) string {
+	userID := int(msg.From.ID)
+	fields := strings.Fields(msg.CommandArguments())
+	if len(fields) < 2 {
+		return "用法：/stopat xx-yy 3h 或 /stopat xx-yy 23:30"
+	}
+	if _, err := b.autodlClient(userID); err != nil {
+		return errorReply(err)
+	}
+	loc := b.userLocation(userID)
+	deadline, err := parseStopTime(strings.Join(fields[1:], " "), loc)
+	if err != nil {
+		return err.Error()
+	}
+	return b.stopDeadlineReply(userID, msg.Chat.ID, fields[0], deadline)
+}
+
+// stopDeadlineReply 设置自动关机时间并返回回复文本
+func (b *Bot) stopDeadlineReply(userID int, chatID int64, uuid string, deadline time.Time) string {
+	id, err := b.setStopDeadline(userID, chatID, uuid, deadline)
+	if errors.Is(err, scheduler.ErrJobRunning) {
+		return fmt.Sprintf("实例 %s 已经开始自动关机，无法修改关机时间，如需继续使用请在关机后重新开机", uuid)
+	}
+	if err != nil {
+		log.Printf("[ERROR] 创建自动关机任务失败: %v", err)
+		return "创建自动关机任务失败，请稍后重试"
+	}
+	return fmt.Sprintf("实例 %s 将在 %s 自动关机（任务 #%d），关机前%s会提醒",
+		uuid, deadline.In(b.userLocation(userID)).Format("01-02 15:04"), id, formatElapsed(stopWarnBefore))
+}
+
+// setStopDeadline 设置按截止时间关机的任务和关机前提醒，已有截止时间时改为新的时间。
+// 关机任务已经开始执行时返回 scheduler.ErrJobRunning
+func (b *Bot) setStopDeadline(userID int, chatID int64, uuid string, deadline time.Time) (int64, error) {
+	jobs, err := b.scheduler.List(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	var stopJob *models.Job
+	for _, job := range jobs {
+		if job.UUID == uuid && job.Kind == jobPowerOff && job.Payload == payloadDeadline {
+			if err := b.scheduler.Reschedule(job, deadline); err != nil {
+				return 0, err
+			}
+			stopJob = job
+			break
+		}
+	}
+	if stopJob == nil {
+		stopJob = &models.Job{
+			TelegramID: userID,
+			ChatID:     chatID,
+			Kind:       jobPowerOff,
+			UUID:       uuid,
+			Payload:    payloadDeadline,
+			RunAt:      deadline,
+		}
+		if _, err := b.scheduler.Schedule(stopJob); err != nil {
+			return 0, err
+		}
+	}
+
+	// 原有的提醒改到新的提醒时间；正在发送的提醒发完即结束，需要另建提醒
+	warnAt := deadline.Add(-stopWarnBefore)
+	warned := false
+	for _, job := range jobs {
+		if job.UUID != uuid || job.Kind != jobStopWarning {
+			continue
+		}
+		if warned || warnAt.Before(time.Now()) {
+			if _, err := b.scheduler.Cancel(userID, job.ID); err != nil {
+				log.Printf("[ERROR] 取消关机提醒失败: %v", err)
+			}
+			continue
+		}
+		job.Payload = strconv.FormatInt(stopJob.ID, 10)
+		err := b.scheduler.Reschedule(job, warnAt)
+		if errors.Is(err, scheduler.ErrJobRunning) {
+			continue
+		}
+		if err != nil {
+			log.Printf("[ERROR] 修改关机提醒失败: %v", err)
+			continue
+		}
+		warned = true
+	}
+	if !warned {
+		b.scheduleStopWarning(stopJob)
+	}
+	return stopJob.ID, nil
+}
+
+// scheduleStopWarning 在关机前stopWarnBefore发送提醒，剩余时间不足时不提醒
+func (b *Bot) scheduleStopWarning(stopJob *models.Job) {
+	warnAt := stopJob.RunAt.Add(-stopWarnBefore)
+	if warnAt.Before(time.Now()) {
+		return
+	}
+	_, err := b.scheduler.Schedule(&models.Job{
+		TelegramID: stopJob.TelegramID,
+		ChatID:     stopJob.ChatID,
+		Kind:       jobStopWarning,
+		UUID:       stopJob.UUID,
+		Payload:    strconv.FormatInt(stopJob.ID, 10),
+		RunAt:      warnAt,
+	})
+	if err != nil {
+		log.Printf("[ERROR] 创建关机提醒失败: %v", err)
+	}
+}
+
+// findJob 查找用户尚未完成的任务
+func (b *Bot) findJob(userID int, id int64) *models.Job {
+	jobs, err := b.scheduler.List(userID)
+	if err != nil {
+		log.Printf("[ERROR] 读取任务失败: %v", err)
+		return nil
+	}
+	for _, job := range jobs {
+		if job.ID == id {
+			return job
+		}
+	}
+	return nil
+}
+
+// stopWarningJob 发送带"延长1小时"按钮的关机提醒，关机任务已取消时不发送
+func (b *Bot) stopWarningJob(ctx context.Context, job *models.Job) (string, error) {
+	stopID, err := strconv.ParseInt(job.Payload, 10, 64)
+	if err != nil {
+		return "", nil
+	}
+	stopJob := b.findJob(job.TelegramID, stopID)
+	if stopJob == nil {
+		return "", nil
+	}
+
+	text := fmt.Sprintf("实例 %s 将在 %s 自动关机，如需继续使用请延长",
+		job.UUID, stopJob.RunAt.In(b.userLocation(job.TelegramID)).Format("15:04"))
+	msg := tgbotapi.NewMessage(job.ChatID, text)
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("延长1小时", b.callbackData(int64(job.TelegramID), actionExtendStop, job.Payload)),
+	))
+	if _, err := b.api.Send(msg); err != nil {
+		return "", err
+	}
+	return "", nil
+}
+
+// extendStopCallback 处理"延长1小时"按钮
+func (b *Bot) extendStopCallback(query *tgbotapi.CallbackQuery, args []string) string {
+	if len(args) != 1 {
+		return "参数错误"
+	}
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return "参数错误"
+	}
+	userID := int(query.From.ID)
+	stopJob := b.findJob(userID, id)
+	if stopJob == nil {
+		b.removeKeyboard(query.Message)
+		return "自动关机任务已不存在"
+	}
+
+	err = b.scheduler.Reschedule(stopJob, stopJob.RunAt.Add(stopExtendStep))
+	if errors.Is(err, scheduler.ErrJobRunning) {
+		b.removeKeyboard(query.Message)
+		b.sendText(query.Message.Chat.ID, fmt.Sprintf("实例 %s 已经开始自动关机，无法延长，如需继续使用请在关机后重新开机", stopJob.UUID))
+		return "已经开始关机，无法延长"
+	}
+	if err != nil {
+		log.Printf("[ERROR] 延长自动关机任务失败: %v", err)
+		return "操作失败，请稍后重试"
+	}
+	b.scheduleStopWarning(stopJob)
+	b.removeKeyboard(query.Message)
+
+	deadline := stopJob.RunAt.In(b.userLocation(userID)).Format("15:04")
+	b.sendText(query.Message.Chat.ID, fmt.Sprintf("实例 %s 的自动关机时间已延长到 %s", stopJob.UUID, deadline))
+	return "已延长到 " + deadline
+}
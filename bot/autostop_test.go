@@ -0,0 +1,241 @@
+package bot
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"autodl_bot/client"
+	"autodl_bot/models"
+	"autodl_bot/scheduler"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStopTime(t *testing.T) {
+	loc := time.FixedZone("UTC+8", 8*3600)
+	now := time.Now().In(loc)
+	tomorrow := now.Add(24 * time.Hour).Truncate(time.Minute)
+	yesterday := now.Add(-24 * time.Hour)
+
+	tests := []struct {
+		name  string
+		value string
+		want  time.Time
+		err   string
+	}{
+		{name: "时长", value: "90m", want: now.Add(90 * time.Minute)},
+		{name: "完整时间", value: tomorrow.Format("2006-01-02 15:04"), want: tomorrow},
+		{name: "用T分隔的完整时间", value: tomorrow.Format("2006-01-02T15:04"), want: tomorrow},
+		{name: "时长为0", value: "0h", err: "时长必须大于0"},
+		{name: "负时长", value: "-1h", err: "时长必须大于0"},
+		{name: "已过的完整时间", value: yesterday.Format("2006-01-02T15:04"), err: "关机时间已过"},
+		{name: "格式错误", value: "明天", err: "时间格式错误，例如：3h、90m、23:30、2024-12-01T23:30"},
+		{name: "只有日期", value: "2024-12-01", err: "时间格式错误，例如：3h、90m、23:30、2024-12-01T23:30"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deadline, err := parseStopTime(tt.value, loc)
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.WithinDuration(t, tt.want, deadline, 5*time.Second)
+			assert.Equal(t, loc, deadline.Location())
+		})
+	}
+}
+
+func TestParseStopTimeClock(t *testing.T) {
+	loc := time.FixedZone("UTC+8", 8*3600)
+	for _, offset := range []time.Duration{-time.Hour, 2 * time.Hour} {
+		clock := time.Now().In(loc).Add(offset)
+		deadline, err := parseStopTime(clock.Format("15:04"), loc)
+		require.NoError(t, err)
+		assert.Equal(t, clock.Format("15:04"), deadline.Format("15:04"))
+		assert.True(t, deadline.After(time.Now()), "当天时刻已过时顺延到次日")
+		assert.True(t, deadline.Before(time.Now().Add(24*time.Hour)))
+	}
+}
+
+func TestStartDeadline(t *testing.T) {
+	loc := time.FixedZone("UTC+8", 8*3600)
+	tomorrow := time.Now().In(loc).Add(24 * time.Hour).Truncate(time.Minute)
+
+	tests := []struct {
+		name string
+		args string
+		want time.Time
+		err  string
+	}{
+		{name: "未设置", args: "xx-yy"},
+		{name: "--for", args: "xx-yy --for 3h", want: time.Now().Add(3 * time.Hour)},
+		{name: "--for优先于--until", args: "xx-yy --for 3h --until 23:30", want: time.Now().Add(3 * time.Hour)},
+		{name: "--until用T分隔", args: "xx-yy --until " + tomorrow.Format("2006-01-02T15:04"), want: tomorrow},
+		{name: "--until带引号", args: `xx-yy --until "` + tomorrow.Format("2006-01-02 15:04") + `"`, want: tomorrow},
+		{name: "--for格式错误", args: "xx-yy --for 3", err: "--for 格式错误，例如：30m、3h"},
+		{name: "--for为负", args: "xx-yy --for -1h", err: "--for 格式错误，例如：30m、3h"},
+		{name: "--until格式错误", args: "xx-yy --until soon", err: "--until 时间格式错误，例如：3h、90m、23:30、2024-12-01T23:30"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, flags, err := parseFlags(tt.args, "for", "until")
+			require.NoError(t, err)
+			deadline, err := startDeadline(flags, loc)
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			if tt.want.IsZero() {
+				assert.True(t, deadline.IsZero())
+				return
+			}
+			assert.WithinDuration(t, tt.want, deadline, 5*time.Second)
+		})
+	}
+}
+
+func callbackQuery(userID, chatID int64) *tgbotapi.CallbackQuery {
+	return &tgbotapi.CallbackQuery{
+		ID:      "1",
+		From:    &tgbotapi.User{ID: userID},
+		Message: &tgbotapi.Message{MessageID: 1, Chat: &tgbotapi.Chat{ID: chatID}},
+	}
+}
+
+func TestExtendStopCallback(t *testing.T) {
+	b, telegram := newTestBot(t)
+	deadline := time.Now().Add(time.Hour)
+	id, err := b.setStopDeadline(1, 10, "xx-yy", deadline)
+	require.NoError(t, err)
+
+	reply := b.extendStopCallback(callbackQuery(1, 10), []string{"99"})
+	assert.Equal(t, "自动关机任务已不存在", reply)
+
+	reply = b.extendStopCallback(callbackQuery(1, 10), []string{strconv.FormatInt(id, 10)})
+	assert.Contains(t, reply, "已延长到")
+	stopJob := b.findJob(1, id)
+	require.NotNil(t, stopJob)
+	assert.WithinDuration(t, deadline.Add(stopExtendStep), stopJob.RunAt, time.Second)
+	assert.Contains(t, telegram.sent(10)[0], "自动关机时间已延长到")
+}
+
+// blockingPowerOff 在关机请求到达时通知测试，并等待release关闭后再处理
+type blockingPowerOff struct {
+	*fakeInstanceAPI
+	arrived chan struct{}
+	release chan struct{}
+}
+
+func (f *blockingPowerOff) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == client.PowerOffPath {
+		close(f.arrived)
+		<-f.release
+	}
+	f.fakeInstanceAPI.ServeHTTP(w, r)
+}
+
+func TestExtendRunningStopJob(t *testing.T) {
+	b, telegram := newTestBot(t)
+	b.scheduler.PollInterval = 10 * time.Millisecond
+	api := &blockingPowerOff{
+		fakeInstanceAPI: &fakeInstanceAPI{instances: []models.Instance{{UUID: "xx-yy", Status: models.StatusRunning}}},
+		arrived:         make(chan struct{}),
+		release:         make(chan struct{}),
+	}
+	useClient(b, 1, newTestClient(t, api))
+	id, err := b.setStopDeadline(1, 10, "xx-yy", time.Now())
+	require.NoError(t, err)
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		b.scheduler.Run(stop)
+		close(done)
+	}()
+	<-api.arrived
+
+	reply := b.extendStopCallback(callbackQuery(1, 10), []string{strconv.FormatInt(id, 10)})
+	assert.Equal(t, "已经开始关机，无法延长", reply)
+	assert.Contains(t, telegram.sent(10)[0], "已经开始自动关机，无法延长")
+
+	close(api.release)
+	require.Eventually(t, func() bool { return len(telegram.sent(10)) == 2 }, 5*time.Second, 10*time.Millisecond)
+	assert.Contains(t, telegram.sent(10)[1], "已关机")
+	close(stop)
+	<-done
+}
+
+func TestSetStopDeadlineReplacesJobs(t *testing.T) {
+	b, _ := newTestBot(t)
+	first, err := b.setStopDeadline(1, 10, "xx-yy", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+
+	deadline := time.Now().Add(3 * time.Hour)
+	id, err := b.setStopDeadline(1, 10, "xx-yy", deadline)
+	require.NoError(t, err)
+	assert.Equal(t, first, id, "沿用原有的关机任务")
+
+	jobs, err := b.scheduler.List(1)
+	require.NoError(t, err)
+	require.Len(t, jobs, 2)
+	for _, job := range jobs {
+		switch job.Kind {
+		case jobPowerOff:
+			assert.WithinDuration(t, deadline, job.RunAt, time.Second)
+		case jobStopWarning:
+			assert.WithinDuration(t, deadline.Add(-stopWarnBefore), job.RunAt, time.Second)
+			assert.Equal(t, strconv.FormatInt(id, 10), job.Payload)
+		default:
+			t.Fatalf("意外的任务类型 %s", job.Kind)
+		}
+	}
+
+	// 新的截止时间来不及提醒时取消原有提醒
+	_, err = b.setStopDeadline(1, 10, "xx-yy", time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	jobs, err = b.scheduler.List(1)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, jobPowerOff, jobs[0].Kind)
+}
+
+func TestSetStopDeadlineWhileStopping(t *testing.T) {
+	b, telegram := newTestBot(t)
+	b.scheduler.PollInterval = 10 * time.Millisecond
+	api := &blockingPowerOff{
+		fakeInstanceAPI: &fakeInstanceAPI{instances: []models.Instance{{UUID: "xx-yy", Status: models.StatusRunning}}},
+		arrived:         make(chan struct{}),
+		release:         make(chan struct{}),
+	}
+	useClient(b, 1, newTestClient(t, api))
+	_, err := b.setStopDeadline(1, 10, "xx-yy", time.Now())
+	require.NoError(t, err)
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		b.scheduler.Run(stop)
+		close(done)
+	}()
+	<-api.arrived
+
+	_, err = b.setStopDeadline(1, 10, "xx-yy", time.Now().Add(time.Hour))
+	assert.ErrorIs(t, err, scheduler.ErrJobRunning)
+	reply := b.stopDeadlineReply(1, 10, "xx-yy", time.Now().Add(time.Hour))
+	assert.Contains(t, reply, "已经开始自动关机，无法修改关机时间")
+	jobs, err := b.scheduler.List(1)
+	require.NoError(t, err)
+	assert.Len(t, jobs, 1, "不创建新的关机任务和提醒")
+
+	close(api.release)
+	require.Eventually(t, func() bool { return len(telegram.sent(10)) == 1 }, 5*time.Second, 10*time.Millisecond)
+	assert.Contains(t, telegram.sent(10)[0], "已关机")
+	close(stop)
+	<-done
+}
@@ -0,0 +1,245 @@
+package bot
+
+import (
+	"autodl_bot/models"
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// balancePollInterval 同时决定预算检查的频率
+	balancePollInterval = 30 * time.Minute
+	// balanceHistoryKeep 是余额记录的保留时间，需要覆盖 /spend month
+	balanceHistoryKeep = 90 * 24 * time.Hour
+
+	settingLowBalance        = "low_balance"
+	settingLowBalanceAlerted = "low_balance_alerted"
+	defaultLowBalance        = 10.0
+)
+
+// runBalancePoller 定期记录每个账号的余额并检查余额不足，直到stop关闭
+func (b *Bot) runBalancePoller(stop <-chan struct{}) {
+	ticker := time.NewTicker(balancePollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.pollBalances()
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (b *Bot) pollBalances() {
+	for _, userID := range b.configuredUsers() {
+		autodl, err := b.backgroundClient(userID)
+		if err != nil {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+		balance, err := autodl.GetBalanceContext(ctx)
+		cancel()
+		if err != nil {
+			log.Printf("[ERROR] 用户%d定期查询余额失败: %v", userID, err)
+			continue
+		}
+		b.recordBalance(userID, balance)
+		b.checkLowBalance(userID, balance)
+		b.enforceBudget(userID, autodl)
+	}
+	if err := b.storage.PruneBalances(time.Now().Add(-balanceHistoryKeep)); err != nil {
+		log.Printf("[ERROR] 清理余额记录失败: %v", err)
+	}
+}
+
+// recordBalance 保存余额记录，/balance 查询到的余额也会记录
+func (b *Bot) recordBalance(userID int, balance float64) {
+	username := b.getUserConfig(userID).Username
+	if err := b.storage.AddBalance(userID, username, balance, time.Now()); err != nil {
+		log.Printf("[ERROR] 保存余额记录失败: %v", err)
+	}
+}
+
+// checkLowBalance 余额低于阈值时提醒一次，余额恢复到阈值以上后重新开始检查
+func (b *Bot) checkLowBalance(userID int, balance float64) {
+	threshold := b.lowBalanceThreshold(userID)
+	if threshold <= 0 {
+		return
+	}
+	_, alerted, err := b.storage.GetSetting(userID, settingLowBalanceAlerted)
+	if err != nil {
+		log.Printf("[ERROR] 读取余额提醒状态失败: %v", err)
+		return
+	}
+
+	if balance >= threshold {
+		if alerted {
+			if err := b.storage.DeleteSetting(userID, settingLowBalanceAlerted); err != nil {
+				log.Printf("[ERROR] 重置余额提醒状态失败: %v", err)
+			}
+		}
+		return
+	}
+	if alerted {
+		return
+	}
+	if err := b.storage.SetSetting(userID, settingLowBalanceAlerted, "1"); err != nil {
+		log.Printf("[ERROR] 保存余额提醒状态失败: %v", err)
+		return
+	}
+
+	text := fmt.Sprintf("余额不足提醒：当前余额 %.2f元，低于 %.2f元，余额耗尽后AutoDL会自动关机，请及时充值", balance, threshold)
+	if spent, _ := b.spendSince(userID, time.Now().Add(-24*time.Hour)); spent > 0 {
+		text += fmt.Sprintf("\n按最近24小时消费 %.2f元 估算，约可使用%s", spent, formatDays(time.Duration(balance/spent*24*float64(time.Hour))))
+	}
+	b.sendText(int64(userID), text)
+}
+
+// lowBalanceThreshold 返回用户设置的余额提醒阈值（元），关闭时返回0
+func (b *Bot) lowBalanceThreshold(userID int) float64 {
+	value, exist, err := b.storage.GetSetting(userID, settingLowBalance)
+	if err != nil {
+		log.Printf("[ERROR] 读取余额提醒设置失败: %v", err)
+	}
+	if !exist {
+		return defaultLowBalance
+	}
+	if value == "" {
+		return 0
+	}
+	threshold, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return defaultLowBalance
+	}
+	return threshold
+}
+
+// lowBalanceCommand 处理 /lowbalance [金额|off]，不带参数时显示当前设置
+func (b *Bot) lowBalanceCommand(msg *tgbotapi.Message) string {
+	userID := int(msg.From.ID)
+	arg := strings.TrimSpace(msg.CommandArguments())
+	switch arg {
+	case "":
+		threshold := b.lowBalanceThreshold(userID)
+		if threshold == 0 {
+			return "余额不足提醒已关闭，使用 /lowbalance 10 开启"
+		}
+		return fmt.Sprintf("余额低于 %.2f元 时提醒", threshold)
+	case "off":
+		if err := b.storage.SetSetting(userID, settingLowBalance, ""); err != nil {
+			log.Printf("[ERROR] 保存余额提醒设置失败: %v", err)
+			return "保存设置失败，请稍后重试"
+		}
+		return "已关闭余额不足提醒"
+	}
+
+	threshold, err := strconv.ParseFloat(arg, 64)
+	if err != nil || threshold <= 0 {
+		return "金额必须是正数，例如：/lowbalance 10"
+	}
+	if err := b.storage.SetSetting(userID, settingLowBalance, strconv.FormatFloat(threshold, 'f', 2, 64)); err != nil {
+		log.Printf("[ERROR] 保存余额提醒设置失败: %v", err)
+		return "保存设置失败，请稍后重试"
+	}
+	// 新阈值下重新判断是否需要提醒
+	if err := b.storage.DeleteSetting(userID, settingLowBalanceAlerted); err != nil {
+		log.Printf("[ERROR] 重置余额提醒状态失败: %v", err)
+	}
+	return fmt.Sprintf("已设置余额低于 %.2f元 时提醒", threshold)
+}
+
+var spendPeriodNames = map[string]string{
+	"day":   "今日",
+	"week":  "本周",
+	"month": "本月",
+}
+
+// spendCommand 处理 /spend [day|week|month]，按余额变化统计消费
+func (b *Bot) spendCommand(msg *tgbotapi.Message) string {
+	userID := int(msg.From.ID)
+	period := strings.TrimSpace(msg.CommandArguments())
+	if period == "" {
+		period = "day"
+	}
+	name, ok := spendPeriodNames[period]
+	if !ok {
+		return "用法：/spend [day|week|month]"
+	}
+	if _, err := b.autodlClient(userID); err != nil {
+		return errorReply(err)
+	}
+
+	start := periodStart(period, time.Now().In(b.userLocation(userID)))
+	spent, recharged, first, err := b.balanceDeltas(userID, start)
+	if err != nil {
+		log.Printf("[ERROR] 读取余额记录失败: %v", err)
+		return "读取余额记录失败，请稍后重试"
+	}
+	if first.IsZero() {
+		return "暂无余额记录，Bot会定期记录余额，请稍后再查询"
+	}
+	reply := fmt.Sprintf("%s消费 %.2f元", name, spent)
+	if recharged > 0 {
+		reply += fmt.Sprintf("，充值 %.2f元", recharged)
+	}
+	if first.After(start) {
+		reply += fmt.Sprintf("\n余额记录从 %s 开始，之前的消费未统计", first.In(start.Location()).Format("01-02 15:04"))
+	}
+	return reply
+}
+
+// periodStart 返回now所在自然日、自然周（周一开始）或自然月的开始时间
+func periodStart(period string, now time.Time) time.Time {
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch period {
+	case "week":
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case "month":
+		return day.AddDate(0, 0, 1-day.Day())
+	}
+	return day
+}
+
+// spendSince 返回since之后的消费金额
+func (b *Bot) spendSince(userID int, since time.Time) (float64, error) {
+	spent, _, _, err := b.balanceDeltas(userID, since)
+	return spent, err
+}
+
+// balanceDeltas 按相邻余额记录的差值统计since之后的消费和充值，同时返回最早一条记录的时间
+func (b *Bot) balanceDeltas(userID int, since time.Time) (spent, recharged float64, first time.Time, err error) {
+	username := b.getUserConfig(userID).Username
+	records, err := b.storage.ListBalances(userID, username, since)
+	if err != nil || len(records) == 0 {
+		return 0, 0, time.Time{}, err
+	}
+	spent, recharged = sumBalanceDeltas(records, since)
+	return spent, recharged, records[0].RecordedAt, nil
+}
+
+// sumBalanceDeltas 余额下降计为消费，上升计为充值。作为起点的记录早于since时，
+// 它与下一条记录之间的变化按时间比例只计入since之后的部分
+func sumBalanceDeltas(records []models.BalanceRecord, since time.Time) (spent, recharged float64) {
+	for i := 1; i < len(records); i++ {
+		delta := records[i].Balance - records[i-1].Balance
+		if prev, next := records[i-1].RecordedAt, records[i].RecordedAt; prev.Before(since) {
+			if !next.After(since) {
+				continue
+			}
+			delta *= float64(next.Sub(since)) / float64(next.Sub(prev))
+		}
+		if delta < 0 {
+			spent -= delta
+		} else {
+			recharged += delta
+		}
+	}
+	return spent, recharged
+}
@@ -0,0 +1,108 @@
+package bot
+
+import (
+	"testing"
+	"time"
+
+	"autodl_bot/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSumBalanceDeltas(t *testing.T) {
+	since := time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time { return since.Add(time.Duration(minutes) * time.Minute) }
+
+	tests := []struct {
+		name      string
+		records   []models.BalanceRecord
+		spent     float64
+		recharged float64
+	}{
+		{name: "没有记录"},
+		{name: "只有一条记录", records: []models.BalanceRecord{{Balance: 100, RecordedAt: at(10)}}},
+		{name: "持续消费", records: []models.BalanceRecord{
+			{Balance: 100, RecordedAt: at(0)}, {Balance: 97.5, RecordedAt: at(30)}, {Balance: 95, RecordedAt: at(60)},
+		}, spent: 5},
+		{name: "消费中途充值", records: []models.BalanceRecord{
+			{Balance: 10, RecordedAt: at(0)}, {Balance: 8, RecordedAt: at(30)},
+			{Balance: 108, RecordedAt: at(60)}, {Balance: 105, RecordedAt: at(90)},
+		}, spent: 5, recharged: 100},
+		{name: "周期开始前的记录只计入周期内的部分", records: []models.BalanceRecord{
+			{Balance: 100, RecordedAt: at(-15)}, {Balance: 98, RecordedAt: at(15)}, {Balance: 97, RecordedAt: at(45)},
+		}, spent: 2},
+		{name: "起点记录刚好在周期开始", records: []models.BalanceRecord{
+			{Balance: 100, RecordedAt: at(0)}, {Balance: 98, RecordedAt: at(15)},
+		}, spent: 2},
+		{name: "没有周期内的记录", records: []models.BalanceRecord{
+			{Balance: 100, RecordedAt: at(-30)}, {Balance: 98, RecordedAt: at(-15)},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spent, recharged := sumBalanceDeltas(tt.records, since)
+			assert.InDelta(t, tt.spent, spent, 1e-9)
+			assert.InDelta(t, tt.recharged, recharged, 1e-9)
+		})
+	}
+}
+
+func TestPeriodStart(t *testing.T) {
+	loc := time.FixedZone("UTC+8", 8*3600)
+	date := func(day, hour int) time.Time { return time.Date(2024, 12, day, hour, 30, 0, 0, loc) }
+
+	tests := []struct {
+		name   string
+		period string
+		now    time.Time
+		want   time.Time
+	}{
+		{name: "当天", period: "day", now: date(4, 15), want: time.Date(2024, 12, 4, 0, 0, 0, 0, loc)},
+		{name: "未知周期按天", period: "year", now: date(4, 15), want: time.Date(2024, 12, 4, 0, 0, 0, 0, loc)},
+		{name: "周三所在的周", period: "week", now: date(4, 15), want: time.Date(2024, 12, 2, 0, 0, 0, 0, loc)},
+		{name: "周一", period: "week", now: date(2, 0), want: time.Date(2024, 12, 2, 0, 0, 0, 0, loc)},
+		{name: "周日属于前一周", period: "week", now: date(1, 23), want: time.Date(2024, 11, 25, 0, 0, 0, 0, loc)},
+		{name: "月初", period: "month", now: date(1, 0), want: time.Date(2024, 12, 1, 0, 0, 0, 0, loc)},
+		{name: "月中", period: "month", now: date(17, 8), want: time.Date(2024, 12, 1, 0, 0, 0, 0, loc)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := periodStart(tt.period, tt.now)
+			assert.True(t, tt.want.Equal(got), "got %s", got)
+			assert.Equal(t, loc, got.Location())
+		})
+	}
+}
+
+func TestBalanceDeltas(t *testing.T) {
+	b, _ := newTestBot(t)
+	b.userConfig[1] = &models.AutoDLConfig{Username: "18900000000"}
+	since := time.Now().Truncate(time.Hour).Add(-2 * time.Hour)
+
+	_, _, first, err := b.balanceDeltas(1, since)
+	require.NoError(t, err)
+	assert.True(t, first.IsZero(), "没有记录")
+
+	for _, record := range []models.BalanceRecord{
+		{Balance: 200, RecordedAt: since.Add(-2 * time.Hour)},
+		{Balance: 100, RecordedAt: since.Add(-30 * time.Minute)},
+		{Balance: 98, RecordedAt: since.Add(30 * time.Minute)},
+		{Balance: 148, RecordedAt: since.Add(60 * time.Minute)},
+		{Balance: 146, RecordedAt: since.Add(90 * time.Minute)},
+	} {
+		require.NoError(t, b.storage.AddBalance(1, "18900000000", record.Balance, record.RecordedAt))
+	}
+	// 其他账号的记录不计入
+	require.NoError(t, b.storage.AddBalance(1, "18900000001", 0, since.Add(45*time.Minute)))
+
+	spent, recharged, first, err := b.balanceDeltas(1, since)
+	require.NoError(t, err)
+	assert.InDelta(t, 3, spent, 1e-9, "起点之前的半段消费不计入")
+	assert.InDelta(t, 50, recharged, 1e-9)
+	assert.True(t, first.Equal(since.Add(-30*time.Minute)))
+
+	spent, err = b.spendSince(1, since.Add(time.Hour))
+	require.NoError(t, err)
+	assert.InDelta(t, 2, spent, 1e-9)
+}
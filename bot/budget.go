@@ -0,0 +1,277 @@
+package bot
+
+import (
+	"autodl_bot/client"
+	"autodl_bot/models"
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	settingBudgetPrefix = "budget_"
+	// settingBudgetWarnedPrefix 记录已发送接近预算提醒的周期开始时间
+	settingBudgetWarnedPrefix = "budget_warned_"
+	// settingBudgetStoppedPrefix 记录已创建超预算关机任务的周期开始时间，
+	// 用户用 /canceljob 取消后本周期内不再自动关机
+	settingBudgetStoppedPrefix = "budget_stopped_"
+	budgetWarnRatio            = 0.8
+	// budgetStopGrace 是超出预算后到自动关机之间的时间，期间可用 /canceljob 取消
+	budgetStopGrace = 15 * time.Minute
+	// budgetStartEstimate 是未指定运行时长的开机（/start、定时计划、自动开机任务）预估费用使用的时长
+	budgetStartEstimate = time.Hour
+	// payloadBudget 标记超出预算自动关机的 power_off 任务
+	payloadBudget = "budget"
+)
+
+var budgetPeriods = []string{"day", "month"}
+
+// budgetCommand 处理 /budget [day|month 金额|off]，不带参数时显示预算和已消费金额
+func (b *Bot) budgetCommand(msg *tgbotapi.Message) string {
+	userID := int(msg.From.ID)
+	args := strings.Fields(msg.CommandArguments())
+	if len(args) == 0 {
+		return b.budgetStatus(userID)
+	}
+	if len(args) != 2 || !containsString(budgetPeriods, args[0]) {
+		return "用法：/budget day 50 或 /budget month 1000，/budget day off 关闭"
+	}
+	period := args[0]
+
+	if args[1] == "off" {
+		if err := b.storage.DeleteSetting(userID, settingBudgetPrefix+period); err != nil {
+			log.Printf("[ERROR] 删除预算设置失败: %v", err)
+			return "保存设置失败，请稍后重试"
+		}
+		return fmt.Sprintf("已关闭%s预算", spendPeriodNames[period])
+	}
+	amount, err := strconv.ParseFloat(args[1], 64)
+	if err != nil || amount <= 0 {
+		return "预算金额必须是正数，例如：/budget day 50"
+	}
+	if err := b.storage.SetSetting(userID, settingBudgetPrefix+period, strconv.FormatFloat(amount, 'f', 2, 64)); err != nil {
+		log.Printf("[ERROR] 保存预算设置失败: %v", err)
+		return "保存设置失败，请稍后重试"
+	}
+	// 调整预算后重新按新的预算自动关机
+	if err := b.storage.DeleteSetting(userID, settingBudgetStoppedPrefix+period); err != nil {
+		log.Printf("[ERROR] 重置预算关机状态失败: %v", err)
+	}
+	return fmt.Sprintf("已设置%s预算 %.2f元，超出后将拒绝开机，并在提醒后自动关闭按量计费实例", spendPeriodNames[period], amount)
+}
+
+// budget 返回用户某个周期的预算（元），未设置时返回0
+func (b *Bot) budget(userID int, period string) float64 {
+	value, exist, err := b.storage.GetSetting(userID, settingBudgetPrefix+period)
+	if err != nil {
+		log.Printf("[ERROR] 读取预算设置失败: %v", err)
+	}
+	if !exist {
+		return 0
+	}
+	amount, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0
+	}
+	return amount
+}
+
+func (b *Bot) budgetStatus(userID int) string {
+	var lines []string
+	for _, period := range budgetPeriods {
+		limit := b.budget(userID, period)
+		if limit == 0 {
+			continue
+		}
+		spent, err := b.periodSpend(userID, period)
+		if err != nil {
+			log.Printf("[ERROR] 读取余额记录失败: %v", err)
+			return "读取余额记录失败，请稍后重试"
+		}
+		lines = append(lines, fmt.Sprintf("%s预算 %.2f元，已消费 %.2f元", spendPeriodNames[period], limit, spent))
+	}
+	if len(lines) == 0 {
+		return "当前没有设置预算，使用 /budget day 50 或 /budget month 1000 设置"
+	}
+	return strings.Join(lines, "\n")
+}
+
+// periodSpend 返回用户在当前自然日或自然月的消费
+func (b *Bot) periodSpend(userID int, period string) (float64, error) {
+	start := periodStart(period, time.Now().In(b.userLocation(userID)))
+	return b.spendSince(userID, start)
+}
+
+// checkStartBudget 预估开机后在duration内的消费，超出预算或无法完成检查时返回拒绝原因
+func (b *Bot) checkStartBudget(ctx context.Context, userID int, autodl *client.AutoDLClient, uuid string, useCPU bool, duration time.Duration) string {
+	var hourly float64
+	loaded := false
+	for _, period := range budgetPeriods {
+		limit := b.budget(userID, period)
+		if limit == 0 {
+			continue
+		}
+		spent, err := b.periodSpend(userID, period)
+		if err != nil {
+			log.Printf("[ERROR] 读取余额记录失败: %v", err)
+			return "读取消费记录失败，无法检查预算，已拒绝开机，请稍后重试"
+		}
+		if spent >= limit {
+			return fmt.Sprintf("%s已消费 %.2f元，超出预算 %.2f元，已拒绝开机，可使用 /budget 调整预算", spendPeriodNames[period], spent, limit)
+		}
+		// 无卡模式费用很低，只检查已消费金额
+		if useCPU {
+			continue
+		}
+		if !loaded {
+			var err error
+			hourly, err = b.projectedHourly(ctx, autodl, uuid)
+			if err != nil {
+				log.Printf("[ERROR] 预估开机费用失败: %v", err)
+				return "预估开机费用失败，无法检查预算，已拒绝开机：" + errorReply(err)
+			}
+			loaded = true
+		}
+		projected := spent + hourly*duration.Hours()
+		if projected > limit {
+			return fmt.Sprintf("预计%s消费将达到 %.2f元（已消费 %.2f元，开机后每小时约 %.2f元，按%s估算），超出预算 %.2f元，已拒绝开机",
+				spendPeriodNames[period], projected, spent, hourly, formatDays(duration), limit)
+		}
+	}
+	return ""
+}
+
+// projectedHourly 返回开启uuid后所有按量计费实例每小时的费用
+func (b *Bot) projectedHourly(ctx context.Context, autodl *client.AutoDLClient, uuid string) (float64, error) {
+	instances, err := autodl.ListAllInstancesContext(ctx, client.InstanceFilter{})
+	if err != nil {
+		return 0, err
+	}
+	var hourly float64
+	for _, instance := range instances {
+		if instance.ChargeType != models.ChargeTypePayg {
+			continue
+		}
+		if instance.UUID == uuid || instance.Status == models.StatusRunning || instance.Status == models.StatusStarting {
+			hourly += client.HourlyPrice(instance)
+		}
+	}
+	return hourly, nil
+}
+
+// enforceBudget 在消费接近预算时提醒，超出预算时提醒并在budgetStopGrace后关闭运行中的按量计费实例，
+// 每个预算周期只自动关机一次
+func (b *Bot) enforceBudget(userID int, autodl *client.AutoDLClient) {
+	over, overPeriod := "", ""
+	for _, period := range budgetPeriods {
+		limit := b.budget(userID, period)
+		if limit == 0 {
+			continue
+		}
+		spent, err := b.periodSpend(userID, period)
+		if err != nil {
+			log.Printf("[ERROR] 读取余额记录失败: %v", err)
+			continue
+		}
+		if spent >= limit {
+			if b.budgetStopped(userID, period) {
+				continue
+			}
+			over = fmt.Sprintf("%s已消费 %.2f元，超出预算 %.2f元", spendPeriodNames[period], spent, limit)
+			overPeriod = period
+			break
+		}
+		if spent >= limit*budgetWarnRatio {
+			b.warnBudgetOnce(userID, period, fmt.Sprintf("预算提醒：%s已消费 %.2f元，达到预算 %.2f元 的%d%%，超出后将自动关闭按量计费实例",
+				spendPeriodNames[period], spent, limit, int(budgetWarnRatio*100)))
+		}
+	}
+	if over == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	instances, err := autodl.ListAllInstancesContext(ctx, client.InstanceFilter{Status: []string{models.StatusRunning}})
+	cancel()
+	if err != nil {
+		log.Printf("[ERROR] 用户%d的预算检查查询实例失败: %v", userID, err)
+		return
+	}
+
+	jobs, err := b.scheduler.List(userID)
+	if err != nil {
+		log.Printf("[ERROR] 读取任务失败: %v", err)
+		return
+	}
+	stopAt := time.Now().Add(budgetStopGrace)
+	// 已经会在stopAt之前关机的实例不需要再创建任务
+	stopping := make(map[string]bool)
+	for _, job := range jobs {
+		if job.Kind == jobPowerOff && !job.RunAt.After(stopAt) {
+			stopping[job.UUID] = true
+		}
+	}
+
+	var stopped []string
+	for _, instance := range instances {
+		if instance.Status != models.StatusRunning || instance.ChargeType != models.ChargeTypePayg || stopping[instance.UUID] {
+			continue
+		}
+		id, err := b.scheduler.Schedule(&models.Job{
+			TelegramID: userID,
+			ChatID:     int64(userID),
+			Kind:       jobPowerOff,
+			UUID:       instance.UUID,
+			Payload:    payloadBudget,
+			RunAt:      stopAt,
+		})
+		if err != nil {
+			log.Printf("[ERROR] 创建预算关机任务失败: %v", err)
+			continue
+		}
+		stopped = append(stopped, fmt.Sprintf("%s（任务 #%d）", instance.UUID, id))
+	}
+	if len(stopped) == 0 {
+		return
+	}
+	if err := b.storage.SetSetting(userID, settingBudgetStoppedPrefix+overPeriod, b.budgetPeriodKey(userID, overPeriod)); err != nil {
+		log.Printf("[ERROR] 保存预算关机状态失败: %v", err)
+	}
+	b.sendText(int64(userID), fmt.Sprintf("%s，以下实例将在 %s 自动关机，如需继续使用请 /canceljob 取消，%s内不会再次因预算自动关机，也可用 /budget 调整预算：\n%s",
+		over, stopAt.In(b.userLocation(userID)).Format("15:04"), spendPeriodNames[overPeriod], strings.Join(stopped, "\n")))
+}
+
+// budgetStopped 判断本预算周期是否已经创建过超预算关机任务
+func (b *Bot) budgetStopped(userID int, period string) bool {
+	stopped, _, err := b.storage.GetSetting(userID, settingBudgetStoppedPrefix+period)
+	if err != nil {
+		log.Printf("[ERROR] 读取预算关机状态失败: %v", err)
+		return false
+	}
+	return stopped == b.budgetPeriodKey(userID, period)
+}
+
+// budgetPeriodKey 用当前预算周期的开始时间标识周期
+func (b *Bot) budgetPeriodKey(userID int, period string) string {
+	return strconv.FormatInt(periodStart(period, time.Now().In(b.userLocation(userID))).Unix(), 10)
+}
+
+// warnBudgetOnce 每个预算周期只发送一次接近预算的提醒
+func (b *Bot) warnBudgetOnce(userID int, period, text string) {
+	key := settingBudgetWarnedPrefix + period
+	start := b.budgetPeriodKey(userID, period)
+	warned, _, err := b.storage.GetSetting(userID, key)
+	if err != nil || warned == start {
+		return
+	}
+	if err := b.storage.SetSetting(userID, key, start); err != nil {
+		log.Printf("[ERROR] 保存预算提醒状态失败: %v", err)
+		return
+	}
+	b.sendText(int64(userID), text)
+}
@@ -0,0 +1,135 @@
+package bot
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"autodl_bot/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setDaySpend 写入余额记录，使用户今日和本月的消费为spent
+func setDaySpend(t *testing.T, b *Bot, spent float64) {
+	start := periodStart("day", time.Now().In(b.userLocation(1)))
+	username := b.getUserConfig(1).Username
+	require.NoError(t, b.storage.AddBalance(1, username, 1000, start))
+	require.NoError(t, b.storage.AddBalance(1, username, 1000-spent, time.Now()))
+}
+
+func TestCheckStartBudget(t *testing.T) {
+	instances := []models.Instance{
+		{UUID: "target", Status: models.StatusShutdown, ChargeType: models.ChargeTypePayg, PaygPrice: 6000},
+		{UUID: "running", Status: models.StatusRunning, ChargeType: models.ChargeTypePayg, PaygPrice: 4000},
+		{UUID: "monthly", Status: models.StatusRunning, ChargeType: models.ChargeTypeMonthly, PaygPrice: 9000},
+	}
+	tests := []struct {
+		name    string
+		budget  string
+		spent   float64
+		useCPU  bool
+		handler http.Handler
+		reason  string // 为空表示允许开机
+	}{
+		{name: "没有预算", spent: 100},
+		{name: "预计不超预算", budget: "50", spent: 30},
+		{name: "已超出预算", budget: "50", spent: 50, reason: "今日已消费 50.00元，超出预算 50.00元"},
+		{name: "预计超出预算", budget: "50", spent: 45,
+			reason: "预计今日消费将达到 55.00元（已消费 45.00元，开机后每小时约 10.00元"},
+		{name: "无卡模式只检查已消费", budget: "50", spent: 45, useCPU: true},
+		{name: "无法预估费用时拒绝", budget: "50", spent: 10, handler: http.NotFoundHandler(),
+			reason: "预估开机费用失败，无法检查预算，已拒绝开机"},
+		{name: "没有预算时不预估费用", spent: 10, handler: http.NotFoundHandler()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, _ := newTestBot(t)
+			handler := tt.handler
+			if handler == nil {
+				handler = &fakeInstanceAPI{instances: instances}
+			}
+			autodl := newTestClient(t, handler)
+			useClient(b, 1, autodl)
+			if tt.budget != "" {
+				require.NoError(t, b.storage.SetSetting(1, settingBudgetPrefix+"day", tt.budget))
+			}
+			setDaySpend(t, b, tt.spent)
+
+			reason := b.checkStartBudget(context.Background(), 1, autodl, "target", tt.useCPU, time.Hour)
+			if tt.reason == "" {
+				assert.Empty(t, reason)
+			} else {
+				assert.Contains(t, reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestEnforceBudget(t *testing.T) {
+	b, telegram := newTestBot(t)
+	api := &fakeInstanceAPI{instances: []models.Instance{
+		{UUID: "payg", Status: models.StatusRunning, ChargeType: models.ChargeTypePayg},
+		{UUID: "monthly", Status: models.StatusRunning, ChargeType: models.ChargeTypeMonthly},
+		{UUID: "stopped", Status: models.StatusShutdown, ChargeType: models.ChargeTypePayg},
+	}}
+	autodl := newTestClient(t, api)
+	useClient(b, 1, autodl)
+	require.NoError(t, b.storage.SetSetting(1, settingBudgetPrefix+"day", "50"))
+	setDaySpend(t, b, 60)
+	budgetJobs := func() []*models.Job {
+		jobs, err := b.scheduler.List(1)
+		require.NoError(t, err)
+		var result []*models.Job
+		for _, job := range jobs {
+			if job.Kind == jobPowerOff && job.Payload == payloadBudget {
+				result = append(result, job)
+			}
+		}
+		return result
+	}
+
+	b.enforceBudget(1, autodl)
+	jobs := budgetJobs()
+	require.Len(t, jobs, 1, "只关闭运行中的按量计费实例")
+	assert.Equal(t, "payg", jobs[0].UUID)
+	assert.WithinDuration(t, time.Now().Add(budgetStopGrace), jobs[0].RunAt, 5*time.Second)
+	require.Len(t, telegram.sent(1), 1)
+	assert.Contains(t, telegram.sent(1)[0], "今日内不会再次因预算自动关机")
+
+	// 用户取消关机任务后，本周期内不再创建
+	ok, err := b.scheduler.Cancel(1, jobs[0].ID)
+	require.NoError(t, err)
+	require.True(t, ok)
+	b.enforceBudget(1, autodl)
+	assert.Empty(t, budgetJobs())
+	assert.Len(t, telegram.sent(1), 1)
+
+	// 调整预算后按新的预算重新检查
+	assert.Contains(t, b.budgetCommand(commandMessage("/budget day 55")), "已设置今日预算 55.00元")
+	b.enforceBudget(1, autodl)
+	assert.Len(t, budgetJobs(), 1)
+	assert.Len(t, telegram.sent(1), 2)
+}
+
+func TestEnforceBudgetWarnsOnce(t *testing.T) {
+	b, telegram := newTestBot(t)
+	api := &fakeInstanceAPI{instances: []models.Instance{
+		{UUID: "payg", Status: models.StatusRunning, ChargeType: models.ChargeTypePayg},
+	}}
+	autodl := newTestClient(t, api)
+	useClient(b, 1, autodl)
+	require.NoError(t, b.storage.SetSetting(1, settingBudgetPrefix+"day", "50"))
+	setDaySpend(t, b, 40)
+
+	b.enforceBudget(1, autodl)
+	b.enforceBudget(1, autodl)
+	sent := telegram.sent(1)
+	require.Len(t, sent, 1)
+	assert.Contains(t, sent[0], "预算提醒：今日已消费 40.00元，达到预算 50.00元 的80%")
+	jobs, err := b.scheduler.List(1)
+	require.NoError(t, err)
+	assert.Empty(t, jobs, "未超出预算时不关机")
+}
@@ -0,0 +1,130 @@
+package bot
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// 内联按钮的回调动作，Telegram限制回调数据最长64字节，因此使用短名称
+const (
+	actionRefresh       = "rf"
+	actionIgnoreRelease = "ig"
+	actionExtendStop    = "ex"
+	actionStopInstance  = "st"
+	actionKeepRunning   = "kp"
+	actionStart         = "sa"
+	actionStartCPU      = "sc"
+)
+
+const (
+	maxCallbackData = 64
+	// callbackSigBytes 是签名截取的字节数，编码后占11个字符
+	callbackSigBytes = 8
+)
+
+// newCallbackKey 由Bot token派生回调签名的密钥，重启后之前发出的按钮仍然有效
+func newCallbackKey(token string) []byte {
+	key := sha256.Sum256([]byte("autodl_bot callback:" + token))
+	return key[:]
+}
+
+// callbackData 把动作和参数编码为按钮回调数据，并附加与用户绑定的签名，
+// 避免伪造的回调数据或转发给其他用户的按钮操作实例
+func (b *Bot) callbackData(userID int64, action string, args ...string) string {
+	payload := strings.Join(append([]string{action}, args...), ":")
+	data := payload + ":" + b.signCallback(userID, payload)
+	if len(data) > maxCallbackData {
+		log.Printf("[ERROR] 回调数据超过%d字节: %s", maxCallbackData, payload)
+	}
+	return data
+}
+
+func (b *Bot) signCallback(userID int64, payload string) string {
+	mac := hmac.New(sha256.New, b.callbackKey)
+	fmt.Fprintf(mac, "%d:%s", userID, payload)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:callbackSigBytes])
+}
+
+// parseCallbackData 校验签名并解析回调数据，签名无效时返回false
+func (b *Bot) parseCallbackData(userID int64, data string) (string, []string, bool) {
+	i := strings.LastIndex(data, ":")
+	if i < 0 {
+		return "", nil, false
+	}
+	payload, sig := data[:i], data[i+1:]
+	if !hmac.Equal([]byte(sig), []byte(b.signCallback(userID, payload))) {
+		return "", nil, false
+	}
+	parts := strings.Split(payload, ":")
+	return parts[0], parts[1:], true
+}
+
+// handleCallback 处理内联按钮的点击
+func (b *Bot) handleCallback(query *tgbotapi.CallbackQuery) {
+	defer func() {
+		if r := recover(); r != nil {
+			b.reportPanic(fmt.Sprintf("用户%d点击按钮 %s", query.From.ID, query.Data), r)
+			b.answerCallback(query, "操作出错，已通知管理员")
+		}
+	}()
+	if !b.authorized(query.From.ID) {
+		b.answerCallback(query, "你没有使用此Bot的权限")
+		return
+	}
+	if !b.allowRate(query.From.ID) {
+		b.answerCallback(query, "操作过于频繁，请稍后再试")
+		return
+	}
+	if query.Message == nil {
+		b.answerCallback(query, "消息已过期")
+		return
+	}
+
+	action, args, ok := b.parseCallbackData(query.From.ID, query.Data)
+	if !ok {
+		log.Printf("[INFO] 用户%d的回调数据签名无效", query.From.ID)
+		b.answerCallback(query, "按钮已失效")
+		return
+	}
+	var answer string
+	switch action {
+	case actionRefresh:
+		answer = b.refreshCallback(query, args)
+	case actionIgnoreRelease:
+		answer = b.ignoreReleaseCallback(query, args)
+	case actionExtendStop:
+		answer = b.extendStopCallback(query, args)
+	case actionStopInstance:
+		answer = b.stopInstanceCallback(query, args)
+	case actionKeepRunning:
+		answer = b.keepRunningCallback(query, args)
+	case actionStart:
+		answer = b.startCallback(query, args, false)
+	case actionStartCPU:
+		answer = b.startCallback(query, args, true)
+	default:
+		answer = "未知操作"
+	}
+	b.answerCallback(query, answer)
+}
+
+func (b *Bot) answerCallback(query *tgbotapi.CallbackQuery, text string) {
+	if _, err := b.api.Request(tgbotapi.NewCallback(query.ID, text)); err != nil {
+		log.Printf("error answering callback: %v", err)
+	}
+}
+
+// removeKeyboard 移除消息上的内联按钮，避免重复点击
+func (b *Bot) removeKeyboard(msg *tgbotapi.Message) {
+	edit := tgbotapi.NewEditMessageReplyMarkup(msg.Chat.ID, msg.MessageID,
+		tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}})
+	if _, err := b.api.Request(edit); err != nil {
+		log.Printf("error removing keyboard: %v", err)
+	}
+}
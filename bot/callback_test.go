@@ -0,0 +1,57 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+
+	"autodl_bot/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCallbackDataSignature(t *testing.T) {
+	b := &Bot{callbackKey: newCallbackKey("test-token")}
+	data := b.callbackData(1, actionIgnoreRelease, "3d2b11a3cf-2c2f5d5a", "1730000000")
+	assert.LessOrEqual(t, len(data), maxCallbackData)
+
+	action, args, ok := b.parseCallbackData(1, data)
+	assert.True(t, ok)
+	assert.Equal(t, actionIgnoreRelease, action)
+	assert.Equal(t, []string{"3d2b11a3cf-2c2f5d5a", "1730000000"}, args)
+
+	_, _, ok = b.parseCallbackData(2, data)
+	assert.False(t, ok, "其他用户不能使用该按钮")
+
+	tampered := strings.Replace(data, "3d2b11a3cf", "0000000000", 1)
+	_, _, ok = b.parseCallbackData(1, tampered)
+	assert.False(t, ok, "修改参数后签名无效")
+
+	_, _, ok = b.parseCallbackData(1, "st:3d2b11a3cf-2c2f5d5a")
+	assert.False(t, ok, "没有签名")
+
+	other := &Bot{callbackKey: newCallbackKey("other-token")}
+	_, _, ok = other.parseCallbackData(1, data)
+	assert.False(t, ok, "不同Bot的密钥不同")
+}
+
+func TestInstanceKeyboard(t *testing.T) {
+	b := &Bot{callbackKey: newCallbackKey("test-token")}
+	buttons := func(status string) []string {
+		keyboard := b.instanceKeyboard(1, models.Instance{UUID: "3d2b11a3cf-2c2f5d5a", Status: status})
+		if keyboard == nil {
+			return nil
+		}
+		var actions []string
+		for _, button := range keyboard.InlineKeyboard[0] {
+			action, args, ok := b.parseCallbackData(1, *button.CallbackData)
+			assert.True(t, ok)
+			assert.Equal(t, []string{"3d2b11a3cf-2c2f5d5a"}, args)
+			actions = append(actions, action)
+		}
+		return actions
+	}
+
+	assert.Equal(t, []string{actionStopInstance}, buttons(models.StatusRunning))
+	assert.Equal(t, []string{actionStart, actionStartCPU, actionRefresh}, buttons(models.StatusShutdown))
+	assert.Nil(t, buttons(models.StatusStarting))
+}
@@ -0,0 +1,184 @@
+package bot
+
+import (
+	"autodl_bot/models"
+	"context"
+	"fmt"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// registerCommands 注册所有命令，顺序即 /help 和命令菜单中的顺序
+func (b *Bot) registerCommands() *router {
+	r := newRouter()
+	for _, cmd := range []command{
+		{name: "help", description: "查看支持的命令", handler: b.helpCommand},
+		{name: "user", description: "设置AutoDL用户名（手机号）", args: []commandArg{arg("手机号")},
+			example: "/user 18900000000", handler: plain(b.userCommand)},
+		{name: "password", description: "设置AutoDL密码并立即登录验证", args: []commandArg{arg("密码")},
+			handler: b.passwordCommand},
+		{name: "login", description: "重新登录AutoDL，需要验证码时按提示输入", handler: b.loginCommand},
+		{name: "code", description: "提交AutoDL登录验证码", args: []commandArg{arg("验证码")},
+			example: "/code 123456", handler: b.codeCommand},
+		{name: "getuser", description: "查看当前已设置的用户", handler: plain(b.getUserCommand)},
+		{name: "gpuvalid", description: "查看GPU实例空闲情况", args: optArgs("status=running,shutdown", "charge=payg", "from=日期", "to=日期"),
+			example: "/gpuvalid status=running charge=payg", handler: b.gpuValidCommand},
+		{name: "start", description: "启动GPU实例", args: []commandArg{arg("uuid|别名"), optArg("--for 时长"), optArg("--until 时间")},
+			example: "/start xx-yy --for 3h", handler: b.startCommand},
+		{name: "startcpu", description: "启动GPU实例(无卡模式)", args: []commandArg{arg("uuid|别名"), optArg("--for 时长"), optArg("--until 时间")},
+			handler: b.startCommand},
+		{name: "stop", description: "关闭GPU实例", args: []commandArg{arg("uuid|别名")}, handler: b.stopCommand},
+		{name: "stopat", description: "到时自动关闭实例", args: []commandArg{arg("uuid"), arg("时长|时间")},
+			example: "/stopat xx-yy 23:30", handler: plain(b.stopAtCommand)},
+		{name: "refresh", description: "刷新GPU实例释放时长", args: []commandArg{arg("uuid|别名")}, handler: b.refreshCommand},
+		{name: "alias", description: "设置实例别名，不带参数时列出别名", args: optArgs("uuid", "别名|off"),
+			example: "/alias xx-yy 训练机", handler: b.aliasCommand},
+		{name: "watch", description: "订阅GPU空闲提醒", args: []commandArg{arg("uuid|机器别名"), optArg("最少空闲GPU数")},
+			example: "/watch xx-yy 2", handler: plain(b.watchCommand)},
+		{name: "unwatch", description: "取消GPU空闲提醒", args: []commandArg{arg("订阅ID|uuid")}, handler: plain(b.unwatchCommand)},
+		{name: "watches", description: "查看GPU空闲提醒", handler: plain(b.watchesCommand)},
+		{name: "snipe", description: "GPU空闲后自动开机，不带参数时列出任务", args: optArgs("uuid", "--gpus N", "--timeout 时长"),
+			example: "/snipe xx-yy --gpus 2 --timeout 6h", handler: plain(b.snipeCommand)},
+		{name: "unsnipe", description: "取消自动开机任务", args: []commandArg{arg("任务ID")}, handler: plain(b.unsnipeCommand)},
+		{name: "autorefresh", description: "释放前自动刷新实例，不带参数时列出策略", args: optArgs("uuid", "释放前天数|off"),
+			example: "/autorefresh xx-yy 3", handler: plain(b.autoRefreshCommand)},
+		{name: "releasewarn", description: "设置实例释放提醒", args: optArgs("天数,天数|off"),
+			example: "/releasewarn 3,1", handler: plain(b.releaseWarnCommand)},
+		{name: "runremind", description: "设置长时间运行提醒", args: optArgs("小时|off"),
+			example: "/runremind 8", handler: plain(b.runRemindCommand)},
+		{name: "schedule", description: "定时开关机实例，不带参数时列出计划", args: optArgs("uuid", "start|startcpu|stop", "cron表达式"),
+			example: `/schedule xx-yy start "0 9 * * 1-5"`, handler: plain(b.scheduleCommand)},
+		{name: "unschedule", description: "删除定时开关机计划", args: []commandArg{arg("计划ID")}, handler: plain(b.unscheduleCommand)},
+		{name: "timezone", description: "设置时区", args: optArgs("时区"),
+			example: "/timezone Asia/Shanghai", handler: plain(b.timezoneCommand)},
+		{name: "jobs", description: "查看待执行的任务", handler: plain(b.jobsCommand)},
+		{name: "canceljob", description: "取消待执行的任务", args: []commandArg{arg("任务ID")}, handler: plain(b.cancelJobCommand)},
+		{name: "balance", description: "查看用户余额", handler: b.balanceCommand},
+		{name: "spend", description: "查看消费统计", args: optArgs("day|week|month"),
+			example: "/spend week", handler: plain(b.spendCommand)},
+		{name: "lowbalance", description: "设置余额不足提醒", args: optArgs("金额|off"),
+			example: "/lowbalance 10", handler: plain(b.lowBalanceCommand)},
+		{name: "budget", description: "设置每日或每月预算", args: optArgs("day|month", "金额|off"),
+			example: "/budget day 50", handler: plain(b.budgetCommand)},
+	} {
+		r.register(cmd)
+	}
+	return r
+}
+
+func (b *Bot) helpCommand(ctx context.Context, msg *tgbotapi.Message) string {
+	return b.commands.helpText()
+}
+
+func (b *Bot) userCommand(msg *tgbotapi.Message) string {
+	userID := int(msg.From.ID)
+	// 复制后整体替换，避免与其他goroutine读取配置冲突
+	cfg := *b.getUserConfig(userID)
+	cfg.Username = msg.CommandArguments()
+	b.SetUserConfig(userID, &cfg)
+	b.resetSession(userID)
+	return "用户名设置成功"
+}
+
+func (b *Bot) getUserCommand(msg *tgbotapi.Message) string {
+	return b.CurrentUser(int(msg.From.ID))
+}
+
+// startCommand 处理 /start 和 /startcpu
+func (b *Bot) startCommand(ctx context.Context, msg *tgbotapi.Message) string {
+	userID := int(msg.From.ID)
+	args, flags, err := parseFlags(msg.CommandArguments(), "for", "until")
+	if err != nil {
+		return err.Error()
+	}
+	if len(args) != 1 {
+		return "请在命令后附带实例UUID，例如：/start xx-yy，可用 --for 3h、--until 23:30 或 --until 2024-12-01T23:30 设置自动关机"
+	}
+	deadline, err := startDeadline(flags, b.userLocation(userID))
+	if err != nil {
+		return err.Error()
+	}
+	autodl, err := b.autodlClient(userID)
+	if err != nil {
+		return errorReply(err)
+	}
+
+	useCPU := msg.Command() == "startcpu"
+	// 设置了自动关机时间的命令无法通过按钮重新执行，候选列表不带按钮
+	action := actionStart
+	if useCPU {
+		action = actionStartCPU
+	}
+	if !deadline.IsZero() {
+		action = ""
+	}
+	uuid, ok := b.instanceArg(ctx, msg, autodl, args[0], action)
+	if !ok {
+		return ""
+	}
+	estimate := budgetStartEstimate
+	if !deadline.IsZero() {
+		estimate = time.Until(deadline)
+	}
+	if reason := b.checkStartBudget(ctx, userID, autodl, uuid, useCPU, estimate); reason != "" {
+		return reason
+	}
+	if err := autodl.PowerOnContext(ctx, uuid, useCPU); err != nil {
+		return errorReply(err)
+	}
+
+	reply := fmt.Sprintf("实例 %s 正在开机…", uuid)
+	if !deadline.IsZero() {
+		reply += "\n" + b.stopDeadlineReply(userID, msg.Chat.ID, uuid, deadline)
+	}
+	go b.reportStatus(msg.Chat.ID, autodl, uuid, models.StatusRunning, time.Now())
+	return reply
+}
+
+func (b *Bot) stopCommand(ctx context.Context, msg *tgbotapi.Message) string {
+	userID := int(msg.From.ID)
+	autodl, err := b.autodlClient(userID)
+	if err != nil {
+		return errorReply(err)
+	}
+	uuid, ok := b.instanceArg(ctx, msg, autodl, msg.CommandArguments(), actionStopInstance)
+	if !ok {
+		return ""
+	}
+	if err := autodl.PowerOffContext(ctx, uuid); err != nil {
+		return errorReply(err)
+	}
+	go b.reportStatus(msg.Chat.ID, autodl, uuid, models.StatusShutdown, time.Now())
+	return fmt.Sprintf("实例 %s 正在关机…", uuid)
+}
+
+func (b *Bot) refreshCommand(ctx context.Context, msg *tgbotapi.Message) string {
+	userID := int(msg.From.ID)
+	autodl, err := b.autodlClient(userID)
+	if err != nil {
+		return errorReply(err)
+	}
+	uuid, ok := b.instanceArg(ctx, msg, autodl, msg.CommandArguments(), actionRefresh)
+	if !ok {
+		return ""
+	}
+	if err := autodl.PowerOnContext(ctx, uuid, true); err != nil {
+		return errorReply(err)
+	}
+	return b.refreshReply(userID, msg.Chat.ID, uuid)
+}
+
+func (b *Bot) balanceCommand(ctx context.Context, msg *tgbotapi.Message) string {
+	userID := int(msg.From.ID)
+	autodl, err := b.autodlClient(userID)
+	if err != nil {
+		return errorReply(err)
+	}
+	balance, err := autodl.GetBalanceContext(ctx)
+	if err != nil {
+		return errorReply(err)
+	}
+	b.recordBalance(userID, balance)
+	return fmt.Sprintf("当前余额: %.2f元", balance)
+}
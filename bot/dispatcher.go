@@ -0,0 +1,134 @@
+package bot
+
+import (
+	"fmt"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	defaultWorkers   = 8
+	defaultQueueSize = 100
+	// perUserQueueSize 限制单个用户排队的更新数，避免一个用户占满全局队列
+	perUserQueueSize = 10
+)
+
+// dispatcher 并发处理不同用户的更新，同一用户的更新按到达顺序依次处理。
+// 最多同时处理workers个用户的更新，排队的更新总数超过queueSize时拒绝新的更新。
+type dispatcher struct {
+	mu        sync.Mutex
+	queues    map[int64][]tgbotapi.Update
+	pending   int
+	queueSize int
+	slots     chan struct{}
+	handle    func(update tgbotapi.Update)
+	wg        sync.WaitGroup
+}
+
+func newDispatcher(workers, queueSize int, handle func(update tgbotapi.Update)) *dispatcher {
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+	return &dispatcher{
+		queues:    make(map[int64][]tgbotapi.Update),
+		queueSize: queueSize,
+		slots:     make(chan struct{}, workers),
+		handle:    handle,
+	}
+}
+
+// submit 把更新放入用户的队列，队列已满时返回false，由调用方提示用户稍后重试
+func (d *dispatcher) submit(userID int64, update tgbotapi.Update) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	queue, active := d.queues[userID]
+	if d.pending >= d.queueSize || len(queue) >= perUserQueueSize {
+		return false
+	}
+	d.queues[userID] = append(queue, update)
+	d.pending++
+	// 用户已有处理中的goroutine时由它继续处理，保证同一用户的顺序
+	if !active {
+		d.wg.Add(1)
+		go d.drain(userID)
+	}
+	return true
+}
+
+// drain 占用一个处理槽，依次处理用户队列中的更新直到队列为空
+func (d *dispatcher) drain(userID int64) {
+	defer d.wg.Done()
+	d.slots <- struct{}{}
+	defer func() { <-d.slots }()
+
+	for {
+		d.mu.Lock()
+		queue := d.queues[userID]
+		if len(queue) == 0 {
+			delete(d.queues, userID)
+			d.mu.Unlock()
+			return
+		}
+		update := queue[0]
+		d.queues[userID] = queue[1:]
+		d.mu.Unlock()
+
+		d.handle(update)
+
+		d.mu.Lock()
+		d.pending--
+		d.mu.Unlock()
+	}
+}
+
+// wait 等待所有已接收的更新处理完成
+func (d *dispatcher) wait() {
+	d.wg.Wait()
+}
+
+// updateUser 返回发起更新的用户，不需要处理的更新返回false
+func updateUser(update tgbotapi.Update) (int64, bool) {
+	switch {
+	case update.CallbackQuery != nil:
+		return update.CallbackQuery.From.ID, true
+	case update.Message != nil && update.Message.From != nil:
+		return update.Message.From.ID, true
+	}
+	return 0, false
+}
+
+// handleUpdate 处理一条更新，panic不会影响其他更新的处理
+func (b *Bot) handleUpdate(update tgbotapi.Update) {
+	if update.CallbackQuery != nil {
+		b.handleCallback(update.CallbackQuery)
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			b.reportPanic(fmt.Sprintf("处理用户%d的消息", update.Message.From.ID), r)
+		}
+	}()
+
+	// process command
+	if update.Message.IsCommand() {
+		b.Command(update.Message)
+	} else if !b.handleCodeReply(update.Message) {
+		// not supported command
+		b.sendText(update.Message.Chat.ID, "未知命令，请使用 /help 查看支持的命令")
+	}
+}
+
+// rejectBusy 在队列已满时告知用户稍后重试
+func (b *Bot) rejectBusy(update tgbotapi.Update) {
+	const busy = "当前请求较多，请稍后重试"
+	if update.CallbackQuery != nil {
+		b.answerCallback(update.CallbackQuery, busy)
+		return
+	}
+	b.sendText(update.Message.Chat.ID, busy)
+}
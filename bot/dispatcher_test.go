@@ -0,0 +1,99 @@
+package bot
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func userUpdate(userID int64, seq int) tgbotapi.Update {
+	return tgbotapi.Update{
+		UpdateID: seq,
+		Message:  &tgbotapi.Message{MessageID: seq, From: &tgbotapi.User{ID: userID}, Chat: &tgbotapi.Chat{ID: userID}},
+	}
+}
+
+func TestDispatcherKeepsPerUserOrder(t *testing.T) {
+	var mu sync.Mutex
+	handled := make(map[int64][]int)
+	d := newDispatcher(4, 100, func(update tgbotapi.Update) {
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		userID := update.Message.From.ID
+		handled[userID] = append(handled[userID], update.Message.MessageID)
+	})
+
+	for seq := 0; seq < 5; seq++ {
+		for userID := int64(1); userID <= 6; userID++ {
+			assert.True(t, d.submit(userID, userUpdate(userID, seq)))
+		}
+	}
+	d.wait()
+
+	for userID := int64(1); userID <= 6; userID++ {
+		assert.Equal(t, []int{0, 1, 2, 3, 4}, handled[userID], "用户%d", userID)
+	}
+}
+
+func TestDispatcherRunsUsersInParallel(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan int64, 3)
+	d := newDispatcher(2, 100, func(update tgbotapi.Update) {
+		started <- update.Message.From.ID
+		<-release
+	})
+
+	d.submit(1, userUpdate(1, 0))
+	d.submit(2, userUpdate(2, 0))
+	d.submit(3, userUpdate(3, 0))
+
+	// 两个处理槽都被占用，第三个用户需要等待
+	<-started
+	<-started
+	select {
+	case userID := <-started:
+		t.Fatalf("用户%d不应在处理槽被占满时开始处理", userID)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	<-started
+	d.wait()
+}
+
+func TestDispatcherRejectsWhenFull(t *testing.T) {
+	release := make(chan struct{})
+	d := newDispatcher(1, 3, func(update tgbotapi.Update) { <-release })
+
+	assert.True(t, d.submit(1, userUpdate(1, 0)))
+	assert.True(t, d.submit(1, userUpdate(1, 1)))
+	assert.True(t, d.submit(2, userUpdate(2, 0)))
+	assert.False(t, d.submit(3, userUpdate(3, 0)), "全局队列已满")
+
+	close(release)
+	d.wait()
+	assert.True(t, d.submit(3, userUpdate(3, 0)), "处理完成后可以继续提交")
+	d.wait()
+
+	block := make(chan struct{})
+	busy := make(chan struct{}, 1)
+	d = newDispatcher(1, 100, func(update tgbotapi.Update) {
+		select {
+		case busy <- struct{}{}:
+		default:
+		}
+		<-block
+	})
+	assert.True(t, d.submit(1, userUpdate(1, 0)))
+	<-busy
+	for seq := 1; seq <= perUserQueueSize; seq++ {
+		assert.True(t, d.submit(1, userUpdate(1, seq)))
+	}
+	assert.False(t, d.submit(1, userUpdate(1, perUserQueueSize+1)), "单个用户的队列已满")
+	assert.True(t, d.submit(2, userUpdate(2, 0)))
+	close(block)
+	d.wait()
+}
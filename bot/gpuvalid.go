@@ -0,0 +1,92 @@
+package bot
+
+import (
+	"autodl_bot/client"
+	"autodl_bot/models"
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// gpuValidCommand 每个实例单独发送一条消息，附带开关机和刷新按钮
+func (b *Bot) gpuValidCommand(ctx context.Context, msg *tgbotapi.Message) string {
+	userID := int(msg.From.ID)
+	autodl, err := b.autodlClient(userID)
+	if err != nil {
+		return errorReply(err)
+	}
+	filter, err := parseInstanceFilter(msg.CommandArguments())
+	if err != nil {
+		return errorReply(err)
+	}
+	instances, err := autodl.ListAllInstancesContext(ctx, filter)
+	if err != nil {
+		return "获取GPU状态失败：" + errorReply(err)
+	}
+	if len(instances) == 0 {
+		return "没有符合条件的实例"
+	}
+
+	aliases := b.aliases(userID)
+	for _, instance := range instances {
+		instanceMsg := tgbotapi.NewMessage(msg.Chat.ID, client.FormatInstance(instance, aliases[instance.UUID]))
+		if keyboard := b.instanceKeyboard(msg.From.ID, instance); keyboard != nil {
+			instanceMsg.ReplyMarkup = *keyboard
+		}
+		if _, err := b.api.Send(instanceMsg); err != nil {
+			log.Printf("error sending message: %v", err)
+		}
+	}
+	return fmt.Sprintf("共%d个实例，可点击实例下方的按钮开关机或刷新", len(instances))
+}
+
+// instanceKeyboard 按实例状态生成可用操作的按钮，开关机中的实例没有按钮
+func (b *Bot) instanceKeyboard(userID int64, instance models.Instance) *tgbotapi.InlineKeyboardMarkup {
+	var row []tgbotapi.InlineKeyboardButton
+	switch instance.Status {
+	case models.StatusRunning:
+		row = append(row,
+			tgbotapi.NewInlineKeyboardButtonData("关机", b.callbackData(userID, actionStopInstance, instance.UUID)))
+	case models.StatusShutdown:
+		row = append(row,
+			tgbotapi.NewInlineKeyboardButtonData("开机", b.callbackData(userID, actionStart, instance.UUID)),
+			tgbotapi.NewInlineKeyboardButtonData("无卡开机", b.callbackData(userID, actionStartCPU, instance.UUID)),
+			tgbotapi.NewInlineKeyboardButtonData("刷新", b.callbackData(userID, actionRefresh, instance.UUID)))
+	default:
+		return nil
+	}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(row)
+	return &keyboard
+}
+
+// startCallback 处理"开机"和"无卡开机"按钮，与 /start 一样检查预算
+func (b *Bot) startCallback(query *tgbotapi.CallbackQuery, args []string, useCPU bool) string {
+	if len(args) != 1 {
+		return "参数错误"
+	}
+	uuid := args[0]
+	userID := int(query.From.ID)
+	chatID := query.Message.Chat.ID
+	autodl, err := b.autodlClient(userID)
+	if err != nil {
+		return errorReply(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+	if reason := b.checkStartBudget(ctx, userID, autodl, uuid, useCPU, budgetStartEstimate); reason != "" {
+		b.sendText(chatID, reason)
+		return "未开机"
+	}
+	if err := autodl.PowerOnContext(ctx, uuid, useCPU); err != nil {
+		b.sendText(chatID, errorReply(err))
+		return "开机失败"
+	}
+	b.removeKeyboard(query.Message)
+	b.sendText(chatID, fmt.Sprintf("实例 %s 正在开机…", uuid))
+	go b.reportStatus(chatID, autodl, uuid, models.StatusRunning, time.Now())
+	return "正在开机"
+}
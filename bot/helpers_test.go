@@ -0,0 +1,118 @@
+package bot
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"autodl_bot/client"
+	"autodl_bot/models"
+	"autodl_bot/scheduler"
+	"autodl_bot/storage"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestStorage 在临时目录中创建数据库
+func newTestStorage(t *testing.T) *storage.UserStorage {
+	userStg, err := storage.OpenUserStorage(filepath.Join(t.TempDir(), "users.db"))
+	require.NoError(t, err)
+	return userStg
+}
+
+// newTestBot 创建连接到模拟Telegram API的Bot，发送的消息记录在返回的fakeTelegram中
+func newTestBot(t *testing.T) (*Bot, *fakeTelegram) {
+	telegram := &fakeTelegram{replies: make(map[int64][]string)}
+	server := httptest.NewServer(telegram)
+	t.Cleanup(server.Close)
+
+	api, err := tgbotapi.NewBotAPIWithClient("test-token", server.URL+"/bot%s/%s", &http.Client{})
+	require.NoError(t, err)
+	b := &Bot{
+		api:           api,
+		userConfig:    make(map[int]*models.AutoDLConfig),
+		storage:       newTestStorage(t),
+		callbackKey:   newCallbackKey("test-token"),
+		limiter:       newRateLimiter(commandBurst, commandRefill),
+		pendingLogins: newPendingLogins(),
+	}
+	b.clients = newClientPool(clientIdleTTL, b.setupClient)
+	b.scheduler = scheduler.New(b.storage, b.sendText)
+	b.scheduler.FormatError = errorReply
+	b.registerJobs()
+	return b, telegram
+}
+
+func (f *fakeTelegram) sent(chatID int64) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.replies[chatID]...)
+}
+
+// fakeInstanceAPI 模拟AutoDL的实例列表和开关机接口，开关机成功时同步修改实例状态
+type fakeInstanceAPI struct {
+	mu        sync.Mutex
+	instances []models.Instance
+	powerCode string   // 开关机接口返回的code，为空时返回Success
+	calls     []string // 依次记录开关机请求的接口路径
+}
+
+func (f *fakeInstanceAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&body)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.URL.Path {
+	case client.InstancePath:
+		json.NewEncoder(w).Encode(models.InstanceResponse{
+			Code: "Success",
+			Data: models.InstancePage{List: f.instances, PageIndex: 1, MaxPage: 1, ResultTotal: len(f.instances)},
+		})
+	case client.PowerOnPath, client.PowerOffPath:
+		f.calls = append(f.calls, r.URL.Path)
+		if f.powerCode != "" {
+			json.NewEncoder(w).Encode(models.PowerResponse{Code: f.powerCode, Msg: "操作失败"})
+			return
+		}
+		status := models.StatusRunning
+		if r.URL.Path == client.PowerOffPath {
+			status = models.StatusShutdown
+		}
+		for i := range f.instances {
+			if f.instances[i].UUID == body["instance_uuid"] {
+				f.instances[i].Status = status
+			}
+		}
+		json.NewEncoder(w).Encode(models.PowerResponse{Code: "Success"})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeInstanceAPI) powerCalls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+// useClient 为用户设置账号，并让后台任务使用指定的客户端
+func useClient(b *Bot, userID int, autodl *client.AutoDLClient) {
+	cfg := models.AutoDLConfig{Username: "18900000000", Password: "password"}
+	b.userConfig[userID] = &cfg
+	b.clients.put(userID, cfg, autodl)
+}
+
+// newTestClient 创建已登录、连接到handler的AutoDL客户端
+func newTestClient(t *testing.T, handler http.Handler) *client.AutoDLClient {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	autodl := client.NewAutoDLClient("18900000000", "password")
+	autodl.SetBaseURL(server.URL)
+	autodl.SetToken("token")
+	return autodl
+}
@@ -0,0 +1,239 @@
+package bot
+
+import (
+	"autodl_bot/client"
+	"autodl_bot/models"
+	"autodl_bot/scheduler"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// 后台任务类型
+const (
+	jobPowerOn  = "power_on"
+	jobPowerOff = "power_off"
+	jobRefresh  = "refresh"
+	// jobStopWarning 在自动关机前提醒用户，Payload为关机任务ID
+	jobStopWarning = "stop_warning"
+)
+
+// payloadCPU 表示 power_on 任务使用无卡模式开机
+const payloadCPU = "cpu"
+
+var jobNames = map[string]string{
+	jobPowerOn:     "开机",
+	jobPowerOff:    "关机",
+	jobRefresh:     "刷新释放时长",
+	jobStopWarning: "关机提醒",
+}
+
+func (b *Bot) registerJobs() {
+	b.scheduler.Register(jobPowerOn, b.powerOnJob)
+	b.scheduler.Register(jobPowerOff, b.powerOffJob)
+	b.scheduler.Register(jobRefresh, b.refreshJob)
+	b.scheduler.Register(jobStopWarning, b.stopWarningJob)
+}
+
+// jobClient 返回任务所属用户的客户端，未设置账号时任务无法重试成功
+func (b *Bot) jobClient(job *models.Job) (*client.AutoDLClient, error) {
+	autodl, err := b.backgroundClient(job.TelegramID)
+	if err != nil {
+		return nil, scheduler.Permanent(err)
+	}
+	return autodl, nil
+}
+
+// jobError 把非临时性的AutoDL错误标记为不需要重试
+func jobError(err error) error {
+	if err == nil || client.IsTemporary(err) {
+		return err
+	}
+	return scheduler.Permanent(err)
+}
+
+func (b *Bot) powerOnJob(ctx context.Context, job *models.Job) (string, error) {
+	autodl, err := b.jobClient(job)
+	if err != nil {
+		return "", err
+	}
+	instance, err := autodl.GetInstanceContext(ctx, job.UUID)
+	if err != nil {
+		return "", jobError(err)
+	}
+	if instance.Status != models.StatusRunning && instance.Status != models.StatusStarting {
+		// 超出预算后每个周期只自动关机一次，之后的定时开机同样需要检查预算
+		if reason := b.checkStartBudget(ctx, job.TelegramID, autodl, job.UUID, job.Payload == payloadCPU, budgetStartEstimate); reason != "" {
+			return "", scheduler.Permanent(errors.New(reason))
+		}
+		if err := autodl.PowerOnContext(ctx, job.UUID, job.Payload == payloadCPU); err != nil {
+			return "", jobError(err)
+		}
+	}
+	if _, err := autodl.WaitForStatusContext(ctx, job.UUID, models.StatusRunning, statusWaitTimeout); err != nil {
+		return "", jobError(err)
+	}
+	return fmt.Sprintf("任务 #%d：实例 %s 已开机", job.ID, job.UUID), nil
+}
+
+func (b *Bot) powerOffJob(ctx context.Context, job *models.Job) (string, error) {
+	autodl, err := b.jobClient(job)
+	if err != nil {
+		return "", err
+	}
+	instance, err := autodl.GetInstanceContext(ctx, job.UUID)
+	if err != nil {
+		return "", jobError(err)
+	}
+	if instance.Status != models.StatusShutdown && instance.Status != models.StatusShuttingDown {
+		if err := autodl.PowerOffContext(ctx, job.UUID); err != nil {
+			return "", jobError(err)
+		}
+	}
+	if _, err := autodl.WaitForStatusContext(ctx, job.UUID, models.StatusShutdown, statusWaitTimeout); err != nil {
+		return "", jobError(err)
+	}
+	return fmt.Sprintf("任务 #%d：实例 %s 已关机", job.ID, job.UUID), nil
+}
+
+// 刷新任务的进度，保存在任务的Payload中，重启或重试后从中断的步骤继续
+const (
+	refreshPending  = ""
+	refreshStarted  = "started"
+	refreshStopping = "stopping"
+)
+
+// refreshJob 以无卡模式开机再关机，重置实例释放时长。只刷新已关机的实例，
+// 避免把用户正在使用的实例关掉
+func (b *Bot) refreshJob(ctx context.Context, job *models.Job) (string, error) {
+	autodl, err := b.jobClient(job)
+	if err != nil {
+		return "", err
+	}
+
+	if job.Payload == refreshPending {
+		instance, err := autodl.GetInstanceContext(ctx, job.UUID)
+		if err != nil {
+			return "", jobError(err)
+		}
+		if instance.Status != models.StatusShutdown {
+			return "", scheduler.Permanent(fmt.Errorf("实例 %s 当前状态为%s，只能刷新已关机的实例，已取消刷新",
+				job.UUID, statusText(instance.Status)))
+		}
+		// 刷新只短暂无卡开机，不检查预算：超出预算时拒绝刷新会导致实例被释放、数据丢失
+		if err := autodl.PowerOnContext(ctx, job.UUID, true); err != nil {
+			return "", jobError(err)
+		}
+		b.saveJobProgress(job, refreshStarted)
+	}
+
+	if job.Payload == refreshStarted {
+		if _, err := autodl.WaitForStatusContext(ctx, job.UUID, models.StatusRunning, statusWaitTimeout); err != nil {
+			return "", jobError(err)
+		}
+		if err := autodl.PowerOffContext(ctx, job.UUID); err != nil {
+			return "", jobError(fmt.Errorf("关机失败，请手动关机：%w", err))
+		}
+		b.saveJobProgress(job, refreshStopping)
+	}
+
+	instance, err := autodl.WaitForStatusContext(ctx, job.UUID, models.StatusShutdown, statusWaitTimeout)
+	if err != nil {
+		return "", jobError(fmt.Errorf("已发送关机请求，但未确认关机完成：%w", err))
+	}
+	if releaseAt, ok := client.ReleaseTime(*instance); ok {
+		return fmt.Sprintf("实例 %s 释放时长已刷新，新的释放时间 %s",
+			job.UUID, releaseAt.Format("2006-01-02 15:04")), nil
+	}
+	return fmt.Sprintf("实例 %s 释放时长已刷新", job.UUID), nil
+}
+
+// saveJobProgress 保存任务进度，保存失败时任务重试会重复当前步骤
+func (b *Bot) saveJobProgress(job *models.Job, progress string) {
+	job.Payload = progress
+	if err := b.storage.UpdateJob(job); err != nil {
+		log.Printf("[ERROR] 保存任务 #%d 进度失败: %v", job.ID, err)
+	}
+}
+
+// scheduleRefresh 创建刷新任务，started表示已经发出了无卡模式开机请求
+func (b *Bot) scheduleRefresh(userID int, chatID int64, uuid string, started bool) (int64, error) {
+	job := &models.Job{
+		TelegramID: userID,
+		ChatID:     chatID,
+		Kind:       jobRefresh,
+		UUID:       uuid,
+	}
+	if started {
+		job.Payload = refreshStarted
+	}
+	return b.scheduler.Schedule(job)
+}
+
+// refreshReply 在已发出无卡模式开机请求后创建刷新任务，并返回回复文本
+func (b *Bot) refreshReply(userID int, chatID int64, uuid string) string {
+	id, err := b.scheduleRefresh(userID, chatID, uuid, true)
+	if err != nil {
+		log.Printf("[ERROR] 创建刷新任务失败: %v", err)
+		return fmt.Sprintf("实例 %s 正在无卡模式开机，但创建自动关机任务失败，请在开机后手动关机", uuid)
+	}
+	return fmt.Sprintf("实例 %s 正在无卡模式开机，刷新任务 #%d 将在开机完成后自动关机", uuid, id)
+}
+
+// hasPendingJob 判断用户是否已有同一实例同一类型的未完成任务
+func (b *Bot) hasPendingJob(userID int, kind, uuid string) bool {
+	jobs, err := b.scheduler.List(userID)
+	if err != nil {
+		log.Printf("[ERROR] 读取任务失败: %v", err)
+		return false
+	}
+	for _, job := range jobs {
+		if job.Kind == kind && job.UUID == uuid {
+			return true
+		}
+	}
+	return false
+}
+
+// jobsCommand 处理 /jobs，列出用户尚未完成的任务
+func (b *Bot) jobsCommand(msg *tgbotapi.Message) string {
+	jobs, err := b.scheduler.List(int(msg.From.ID))
+	if err != nil {
+		log.Printf("[ERROR] 读取任务失败: %v", err)
+		return "读取任务失败，请稍后重试"
+	}
+	if len(jobs) == 0 {
+		return "当前没有待执行的任务"
+	}
+	reply := "待执行的任务：\n"
+	for _, job := range jobs {
+		reply += fmt.Sprintf("#%d %s %s %s", job.ID, jobNames[job.Kind], job.UUID, job.RunAt.Format("01-02 15:04"))
+		if job.Attempts > 0 {
+			reply += fmt.Sprintf("（已重试%d次：%s）", job.Attempts, job.LastError)
+		}
+		reply += "\n"
+	}
+	return reply
+}
+
+// cancelJobCommand 处理 /canceljob <任务ID>
+func (b *Bot) cancelJobCommand(msg *tgbotapi.Message) string {
+	id, err := strconv.ParseInt(strings.TrimPrefix(strings.TrimSpace(msg.CommandArguments()), "#"), 10, 64)
+	if err != nil {
+		return "请在命令后附带任务ID，例如：/canceljob 3"
+	}
+	ok, err := b.scheduler.Cancel(int(msg.From.ID), id)
+	if err != nil {
+		log.Printf("[ERROR] 取消任务失败: %v", err)
+		return "取消任务失败，请稍后重试"
+	}
+	if !ok {
+		return "没有找到对应的任务，请使用 /jobs 查看"
+	}
+	return fmt.Sprintf("已取消任务 #%d", id)
+}
@@ -0,0 +1,92 @@
+package bot
+
+import (
+	"testing"
+	"time"
+
+	"autodl_bot/client"
+	"autodl_bot/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// runJob 创建任务并运行调度器，直到会话收到任务结果
+func runJob(t *testing.T, b *Bot, telegram *fakeTelegram, job *models.Job) string {
+	b.scheduler.PollInterval = 10 * time.Millisecond
+	_, err := b.scheduler.Schedule(job)
+	require.NoError(t, err)
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		b.scheduler.Run(stop)
+		close(done)
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+	require.Eventually(t, func() bool { return len(telegram.sent(job.ChatID)) > 0 }, 5*time.Second, 10*time.Millisecond)
+	return telegram.sent(job.ChatID)[0]
+}
+
+func TestRefreshJob(t *testing.T) {
+	tests := []struct {
+		name   string
+		status string
+		calls  []string
+		reply  string
+	}{
+		{name: "已关机的实例", status: models.StatusShutdown,
+			calls: []string{client.PowerOnPath, client.PowerOffPath}, reply: "实例 xx-yy 释放时长已刷新"},
+		{name: "运行中的实例", status: models.StatusRunning, reply: "实例 xx-yy 当前状态为运行，只能刷新已关机的实例"},
+		{name: "开机中的实例", status: models.StatusStarting, reply: "实例 xx-yy 当前状态为开机中，只能刷新已关机的实例"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, telegram := newTestBot(t)
+			api := &fakeInstanceAPI{instances: []models.Instance{{UUID: "xx-yy", Status: tt.status}}}
+			useClient(b, 1, newTestClient(t, api))
+
+			reply := runJob(t, b, telegram, &models.Job{TelegramID: 1, ChatID: 10, Kind: jobRefresh, UUID: "xx-yy"})
+			assert.Contains(t, reply, tt.reply)
+			assert.Equal(t, tt.calls, api.powerCalls())
+			jobs, err := b.scheduler.List(1)
+			require.NoError(t, err)
+			assert.Empty(t, jobs, "状态不符时不重试")
+		})
+	}
+}
+
+func TestPowerOnJobBudget(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		spent   float64
+		calls   []string
+		reply   string
+	}{
+		{name: "预算内开机", spent: 10, calls: []string{client.PowerOnPath}, reply: "任务 #1：实例 xx-yy 已开机"},
+		{name: "预计超出预算", spent: 45, reply: "任务 #1 执行失败：预计今日消费将达到 55.00元"},
+		{name: "已超出预算的无卡模式", payload: payloadCPU, spent: 50, reply: "任务 #1 执行失败：今日已消费 50.00元，超出预算 50.00元"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, telegram := newTestBot(t)
+			api := &fakeInstanceAPI{instances: []models.Instance{
+				{UUID: "xx-yy", Status: models.StatusShutdown, ChargeType: models.ChargeTypePayg, PaygPrice: 10000},
+			}}
+			useClient(b, 1, newTestClient(t, api))
+			require.NoError(t, b.storage.SetSetting(1, settingBudgetPrefix+"day", "50"))
+			setDaySpend(t, b, tt.spent)
+
+			reply := runJob(t, b, telegram, &models.Job{TelegramID: 1, ChatID: 10, Kind: jobPowerOn, UUID: "xx-yy", Payload: tt.payload})
+			assert.Contains(t, reply, tt.reply)
+			assert.Equal(t, tt.calls, api.powerCalls())
+			jobs, err := b.scheduler.List(1)
+			require.NoError(t, err)
+			assert.Empty(t, jobs, "超出预算时不重试")
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"autodl_bot/client"
+	"autodl_bot/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTelegram 模拟Telegram Bot API：getUpdates 一次性返回预设的更新，sendMessage 按会话记录回复
+type fakeTelegram struct {
+	mu        sync.Mutex
+	updates   []map[string]interface{}
+	delivered bool
+	replies   map[int64][]string
+	answers   []string // answerCallbackQuery 的文本
+	total     int
+}
+
+func (f *fakeTelegram) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	var result interface{} = true
+	switch r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:] {
+	case "getMe":
+		result = map[string]interface{}{"id": 1, "is_bot": true, "first_name": "bot", "username": "test_bot"}
+	case "getUpdates":
+		f.mu.Lock()
+		if !f.delivered {
+			result, f.delivered = f.updates, true
+			f.mu.Unlock()
+			break
+		}
+		f.mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		result = []interface{}{}
+	case "sendMessage":
+		chatID, _ := strconv.ParseInt(r.FormValue("chat_id"), 10, 64)
+		f.mu.Lock()
+		f.replies[chatID] = append(f.replies[chatID], r.FormValue("text"))
+		f.total++
+		f.mu.Unlock()
+		result = map[string]interface{}{"message_id": 1, "date": 0, "chat": map[string]interface{}{"id": chatID, "type": "private"}}
+	case "sendPhoto":
+		// 图片以multipart上传，按"[图片] 说明"记录到会话中
+		r.ParseMultipartForm(1 << 20)
+		chatID, _ := strconv.ParseInt(r.FormValue("chat_id"), 10, 64)
+		f.mu.Lock()
+		f.replies[chatID] = append(f.replies[chatID], "[图片] "+r.FormValue("caption"))
+		f.mu.Unlock()
+		result = map[string]interface{}{"message_id": 1, "date": 0, "chat": map[string]interface{}{"id": chatID, "type": "private"}}
+	case "answerCallbackQuery":
+		f.mu.Lock()
+		f.answers = append(f.answers, r.FormValue("text"))
+		f.mu.Unlock()
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": result})
+}
+
+func (f *fakeTelegram) answered() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.answers...)
+}
+
+func (f *fakeTelegram) replyCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.total
+}
+
+// fakeAutoDL 模拟AutoDL接口，记录余额请求的全局并发数和单个用户的并发数
+type fakeAutoDL struct {
+	mu            sync.Mutex
+	inFlight      int
+	maxInFlight   int
+	userInFlight  map[string]int
+	maxPerUser    int
+	balanceDelay  time.Duration
+	balanceByUser map[string]int
+}
+
+func (f *fakeAutoDL) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body map[string]string
+	json.NewDecoder(r.Body).Decode(&body)
+	switch r.URL.Path {
+	case client.LoginPATH:
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"code": "Success", "data": map[string]string{"ticket": "ticket-" + body["phone"]},
+		})
+	case client.PassportPath:
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"code": "Success", "data": map[string]string{"token": "token-" + body["ticket"]},
+		})
+	case client.BalancePath:
+		user := r.Header.Get("authorization")
+		f.mu.Lock()
+		f.inFlight++
+		f.userInFlight[user]++
+		f.maxInFlight = max(f.maxInFlight, f.inFlight)
+		f.maxPerUser = max(f.maxPerUser, f.userInFlight[user])
+		f.balanceByUser[user]++
+		f.mu.Unlock()
+
+		time.Sleep(f.balanceDelay)
+
+		f.mu.Lock()
+		f.inFlight--
+		f.userInFlight[user]--
+		f.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"code": "Success", "data": map[string]int{"assets": 12340},
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func commandUpdate(updateID int, userID int64, text string) map[string]interface{} {
+	name, _, _ := strings.Cut(strings.TrimPrefix(text, "/"), " ")
+	return map[string]interface{}{
+		"update_id": updateID,
+		"message": map[string]interface{}{
+			"message_id": updateID,
+			"date":       0,
+			"from":       map[string]interface{}{"id": userID, "is_bot": false, "first_name": "user"},
+			"chat":       map[string]interface{}{"id": userID, "type": "private"},
+			"text":       text,
+			"entities":   []map[string]interface{}{{"type": "bot_command", "offset": 0, "length": len(name) + 1}},
+		},
+	}
+}
+
+func TestBotLoad(t *testing.T) {
+	const users = 20
+	commands := []string{"/balance", "/timezone UTC", "/balance", "/timezone Asia/Tokyo"}
+
+	dbPath := filepath.Join(t.TempDir(), "users.db")
+	seed, err := storage.OpenUserStorage(dbPath)
+	require.NoError(t, err)
+
+	telegram := &fakeTelegram{replies: make(map[int64][]string)}
+	autodl := &fakeAutoDL{
+		userInFlight:  make(map[string]int),
+		balanceByUser: make(map[string]int),
+		balanceDelay:  100 * time.Millisecond,
+	}
+	updateID := 1
+	for i := 0; i < len(commands); i++ {
+		for userID := int64(1); userID <= users; userID++ {
+			if i == 0 {
+				require.NoError(t, seed.SaveUser(int(userID), fmt.Sprintf("1890000%04d", userID), "password"))
+			}
+			telegram.updates = append(telegram.updates, commandUpdate(updateID, userID, commands[i]))
+			updateID++
+		}
+	}
+
+	tgServer := httptest.NewServer(telegram)
+	defer tgServer.Close()
+	autodlServer := httptest.NewServer(autodl)
+	defer autodlServer.Close()
+
+	b, err := NewBot("test-token", nil, Options{
+		APIEndpoint:   tgServer.URL + "/bot%s/%s",
+		AutoDLBaseURL: autodlServer.URL,
+		DBPath:        dbPath,
+		QueueSize:     users * len(commands),
+	})
+	require.NoError(t, err)
+
+	done := make(chan error)
+	go func() { done <- b.Start() }()
+	require.Eventually(t, func() bool {
+		return telegram.replyCount() == users*len(commands)
+	}, 10*time.Second, 10*time.Millisecond)
+	b.Stop()
+	require.NoError(t, <-done)
+
+	for userID := int64(1); userID <= users; userID++ {
+		replies := telegram.replies[userID]
+		require.Len(t, replies, len(commands), "用户%d", userID)
+		assert.Equal(t, "当前余额: 12.34元", replies[0])
+		assert.Contains(t, replies[1], "时区已设置为 UTC")
+		assert.Equal(t, "当前余额: 12.34元", replies[2])
+		assert.Contains(t, replies[3], "时区已设置为 Asia/Tokyo")
+	}
+	assert.Len(t, autodl.balanceByUser, users)
+	assert.Greater(t, autodl.maxInFlight, 1, "不同用户的命令应并发处理")
+	assert.Equal(t, 1, autodl.maxPerUser, "同一用户的命令应依次处理")
+}
@@ -0,0 +1,202 @@
+package bot
+
+import (
+	"autodl_bot/client"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// passwordCommand 使用新密码登录AutoDL，登录成功后才替换原来的密码，
+// 避免输错密码后原来可用的账号也无法使用
+func (b *Bot) passwordCommand(ctx context.Context, msg *tgbotapi.Message) string {
+	userID := int(msg.From.ID)
+	cfg := b.getUserConfig(userID)
+	if cfg.Username == "" {
+		return "请先使用 /user 设置用户名"
+	}
+	password := client.HashPassword(msg.CommandArguments())
+	autodl := b.passwordClient(userID, password)
+
+	err := autodl.LoginContext(ctx)
+	if err == nil {
+		b.commitPassword(userID, password, autodl)
+		return "密码设置成功，AutoDL登录成功"
+	}
+	if isLoginChallenge(err) {
+		// 验证通过后才保存新密码
+		return b.startVerification(ctx, msg.Chat.ID, userID, &pendingLogin{
+			autodl:    autodl,
+			onSuccess: func() { b.commitPassword(userID, password, autodl) },
+		}, err)
+	}
+	if cfg.Password == "" {
+		return "登录失败，密码未保存：" + errorReply(err)
+	}
+	return "登录失败，仍使用原来的密码：" + errorReply(err)
+}
+
+// passwordClient 使用当前用户名和新密码创建客户端，用于在替换密码前验证登录
+func (b *Bot) passwordClient(userID int, password string) *client.AutoDLClient {
+	cfg := *b.getUserConfig(userID)
+	cfg.Password = password
+	autodl := client.NewAutoDLClient(cfg.Username, cfg.Password)
+	b.setupClient(userID, cfg, autodl)
+	return autodl
+}
+
+// commitPassword 保存验证通过的新密码，并继续使用已登录的客户端
+func (b *Bot) commitPassword(userID int, password string, autodl *client.AutoDLClient) {
+	cfg := *b.getUserConfig(userID)
+	cfg.Password = password
+	b.SetUserConfig(userID, &cfg)
+	b.clients.put(userID, cfg, autodl)
+	if err := b.storage.SaveUser(userID, cfg.Username, cfg.Password); err != nil {
+		log.Printf("[ERROR] 保存用户%d的配置失败: %v", userID, err)
+	}
+}
+
+// pendingLoginTTL 是等待用户回复验证码的最长时间
+const pendingLoginTTL = 5 * time.Minute
+
+// pendingLogin 记录等待用户输入验证码的登录
+type pendingLogin struct {
+	autodl    *client.AutoDLClient
+	pictureID string // 图形验证码ID，短信验证时为空
+	onSuccess func() // 登录成功后调用，可为nil
+	expiresAt time.Time
+}
+
+// pendingLogins 按Telegram用户ID保存等待验证码的登录，每个用户只保留最新的一个
+type pendingLogins struct {
+	mu    sync.Mutex
+	items map[int]*pendingLogin
+}
+
+func newPendingLogins() *pendingLogins {
+	return &pendingLogins{items: make(map[int]*pendingLogin)}
+}
+
+func (p *pendingLogins) set(userID int, pending *pendingLogin) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pending.expiresAt = time.Now().Add(pendingLoginTTL)
+	p.items[userID] = pending
+}
+
+// take 取出并移除用户未过期的登录，没有时返回nil
+func (p *pendingLogins) take(userID int) *pendingLogin {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pending, exist := p.items[userID]
+	if !exist {
+		return nil
+	}
+	delete(p.items, userID)
+	if time.Now().After(pending.expiresAt) {
+		return nil
+	}
+	return pending
+}
+
+func (p *pendingLogins) has(userID int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pending, exist := p.items[userID]
+	return exist && time.Now().Before(pending.expiresAt)
+}
+
+// isLoginChallenge 判断登录错误是否表示需要验证码
+func isLoginChallenge(err error) bool {
+	return errors.Is(err, client.ErrCaptchaRequired) || errors.Is(err, client.ErrSMSCodeRequired)
+}
+
+// loginCommand 处理 /login，使用已保存的账号重新登录，需要验证码时发起验证
+func (b *Bot) loginCommand(ctx context.Context, msg *tgbotapi.Message) string {
+	userID := int(msg.From.ID)
+	autodl, err := b.autodlClient(userID)
+	if err != nil {
+		return errorReply(err)
+	}
+	err = autodl.LoginWithVerificationContext(ctx, client.LoginVerification{})
+	if err == nil {
+		return "AutoDL登录成功"
+	}
+	if isLoginChallenge(err) {
+		return b.startVerification(ctx, msg.Chat.ID, userID, &pendingLogin{autodl: autodl}, err)
+	}
+	return "登录失败：" + errorReply(err)
+}
+
+// startVerification 根据登录错误发送短信验证码或图形验证码，记录等待中的登录并返回给用户的提示
+func (b *Bot) startVerification(ctx context.Context, chatID int64, userID int, pending *pendingLogin, loginErr error) string {
+	pending.pictureID = ""
+	if errors.Is(loginErr, client.ErrSMSCodeRequired) {
+		if err := pending.autodl.SendSMSCodeContext(ctx); err != nil {
+			return "发送短信验证码失败：" + errorReply(err)
+		}
+		b.pendingLogins.set(userID, pending)
+		return fmt.Sprintf("AutoDL需要短信验证，验证码已发送到账号绑定的手机，请在%s内使用 /code 123456 完成登录",
+			formatElapsed(pendingLoginTTL))
+	}
+
+	captcha, err := pending.autodl.GetCaptchaContext(ctx)
+	if err != nil {
+		return "获取图形验证码失败：" + errorReply(err)
+	}
+	photo := tgbotapi.NewPhoto(chatID, tgbotapi.FileBytes{Name: "captcha.png", Bytes: captcha.Image})
+	photo.Caption = "AutoDL登录需要图形验证码"
+	if _, err := b.api.Send(photo); err != nil {
+		log.Printf("error sending captcha: %v", err)
+		return "发送图形验证码失败，请稍后重试"
+	}
+	pending.pictureID = captcha.ID
+	b.pendingLogins.set(userID, pending)
+	return fmt.Sprintf("请在%s内直接回复图中的字符，或使用 /code 字符 完成登录", formatElapsed(pendingLoginTTL))
+}
+
+// codeCommand 处理 /code，提交等待中的登录所需的验证码
+func (b *Bot) codeCommand(ctx context.Context, msg *tgbotapi.Message) string {
+	code := strings.TrimSpace(msg.CommandArguments())
+	if code == "" {
+		return "请在命令后附带验证码，例如：/code 123456"
+	}
+	return b.finishVerification(ctx, msg.Chat.ID, int(msg.From.ID), code)
+}
+
+// handleCodeReply 把非命令消息作为验证码提交，用户没有等待中的登录时返回false
+func (b *Bot) handleCodeReply(msg *tgbotapi.Message) bool {
+	if !b.pendingLogins.has(int(msg.From.ID)) {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+	b.sendText(msg.Chat.ID, b.finishVerification(ctx, msg.Chat.ID, int(msg.From.ID), strings.TrimSpace(msg.Text)))
+	return true
+}
+
+// finishVerification 使用验证码完成登录，仍需验证时重新发起验证
+func (b *Bot) finishVerification(ctx context.Context, chatID int64, userID int, code string) string {
+	pending := b.pendingLogins.take(userID)
+	if pending == nil {
+		return "当前没有等待验证码的登录，或验证码已过期，请使用 /login 重新登录"
+	}
+
+	err := pending.autodl.LoginWithVerificationContext(ctx, client.LoginVerification{PictureID: pending.pictureID, VCode: code})
+	if err == nil {
+		if pending.onSuccess != nil {
+			pending.onSuccess()
+		}
+		return "AutoDL登录成功，请重新执行刚才的命令"
+	}
+	if isLoginChallenge(err) {
+		return "验证码错误或已失效，" + b.startVerification(ctx, chatID, userID, pending, err)
+	}
+	return "登录失败：" + errorReply(err)
+}
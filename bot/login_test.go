@@ -0,0 +1,199 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"autodl_bot/client"
+	"autodl_bot/models"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newLoginServer 模拟AutoDL登录接口，只有密码为right时登录成功
+func newLoginServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		switch r.URL.Path {
+		case client.LoginPATH:
+			if body["password"] != client.HashPassword("right") {
+				json.NewEncoder(w).Encode(map[string]string{"code": "PasswordIncorrect", "msg": "密码错误"})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"code": "Success", "data": map[string]string{"ticket": "ticket"},
+			})
+		case client.PassportPath:
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"code": "Success", "data": map[string]string{"token": "new-token"},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestPasswordCommandVerifiesLogin(t *testing.T) {
+	server := newLoginServer(t)
+	userStg := newTestStorage(t)
+	old := client.HashPassword("old")
+	b := &Bot{
+		userConfig:    map[int]*models.AutoDLConfig{1: {Username: "18900000000", Password: old}},
+		storage:       userStg,
+		autodlBaseURL: server.URL,
+	}
+	b.clients = newClientPool(clientIdleTTL, b.setupClient)
+	previous, err := b.autodlClient(1)
+	require.NoError(t, err)
+
+	reply := b.passwordCommand(context.Background(), commandMessage("/password wrong"))
+	assert.Contains(t, reply, "仍使用原来的密码")
+	assert.Contains(t, reply, "密码错误")
+	assert.Equal(t, old, b.getUserConfig(1).Password)
+	current, err := b.autodlClient(1)
+	require.NoError(t, err)
+	assert.Same(t, previous, current, "登录失败时保留原来的客户端")
+
+	reply = b.passwordCommand(context.Background(), commandMessage("/password right"))
+	assert.Contains(t, reply, "AutoDL登录成功")
+	assert.Equal(t, client.HashPassword("right"), b.getUserConfig(1).Password)
+	current, err = b.autodlClient(1)
+	require.NoError(t, err)
+	assert.NotSame(t, previous, current)
+
+	token, _, err := userStg.LoadToken(1, "18900000000")
+	require.NoError(t, err)
+	assert.Equal(t, "new-token", token)
+	users, err := userStg.LoadUser()
+	require.NoError(t, err)
+	assert.Equal(t, client.HashPassword("right"), users[1].Password)
+
+	b.userConfig[2] = &models.AutoDLConfig{}
+	msg := commandMessage("/password right")
+	msg.From.ID = 2
+	assert.Contains(t, b.passwordCommand(context.Background(), msg), "请先使用 /user 设置用户名")
+}
+
+func TestPasswordCommandFirstTimeFailure(t *testing.T) {
+	server := newLoginServer(t)
+	b := &Bot{
+		userConfig:    map[int]*models.AutoDLConfig{1: {Username: "18900000000"}},
+		storage:       newTestStorage(t),
+		autodlBaseURL: server.URL,
+	}
+	b.clients = newClientPool(clientIdleTTL, b.setupClient)
+
+	reply := b.passwordCommand(context.Background(), commandMessage("/password wrong"))
+	assert.Contains(t, reply, "登录失败，密码未保存")
+	assert.NotContains(t, reply, "原来的密码")
+	assert.Contains(t, reply, "密码错误")
+	assert.Empty(t, b.getUserConfig(1).Password)
+	_, err := b.autodlClient(1)
+	assert.ErrorIs(t, err, errNoCredentials)
+}
+
+// newVerifyServer 模拟需要验证码的AutoDL登录：密码为right且附带图形验证码abcd或短信验证码123456时登录成功
+func newVerifyServer(t *testing.T, challenge string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case client.CaptchaPath:
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"code": "Success", "data": map[string]string{"id": "picture-1", "image": "aW1hZ2U="},
+			})
+		case client.SendSMSPath:
+			json.NewEncoder(w).Encode(models.BaseResponse{Code: "Success"})
+		case client.LoginPATH:
+			var req models.LoginRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			passed := req.PictureID == "picture-1" && req.VCode == "abcd" || req.PictureID == nil && req.VCode == "123456"
+			if req.Password != client.HashPassword("right") {
+				json.NewEncoder(w).Encode(models.BaseResponse{Code: "PasswordIncorrect", Msg: "密码错误"})
+				return
+			}
+			if !passed {
+				json.NewEncoder(w).Encode(models.BaseResponse{Code: challenge, Msg: "需要验证码"})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"code": "Success", "data": map[string]string{"ticket": "ticket"},
+			})
+		case client.PassportPath:
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"code": "Success", "data": map[string]string{"token": "new-token"},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestPasswordCommandCaptcha(t *testing.T) {
+	b, telegram := newTestBot(t)
+	b.autodlBaseURL = newVerifyServer(t, client.CaptchaRequiredCode).URL
+	old := client.HashPassword("old")
+	b.userConfig[1] = &models.AutoDLConfig{Username: "18900000000", Password: old}
+
+	reply := b.passwordCommand(context.Background(), commandMessage("/password right"))
+	assert.Contains(t, reply, "直接回复图中的字符")
+	assert.Equal(t, []string{"[图片] AutoDL登录需要图形验证码"}, telegram.sent(1))
+	assert.Equal(t, old, b.getUserConfig(1).Password, "验证通过前不保存新密码")
+
+	// 回复错误的验证码时重新发送图片
+	wrong := &tgbotapi.Message{Text: "zzzz", From: &tgbotapi.User{ID: 1}, Chat: &tgbotapi.Chat{ID: 1}}
+	require.True(t, b.handleCodeReply(wrong))
+	sent := telegram.sent(1)
+	require.Len(t, sent, 3)
+	assert.Equal(t, "[图片] AutoDL登录需要图形验证码", sent[1])
+	assert.Contains(t, sent[2], "验证码错误或已失效")
+	assert.Equal(t, old, b.getUserConfig(1).Password)
+
+	reply = b.codeCommand(context.Background(), commandMessage("/code abcd"))
+	assert.Equal(t, "AutoDL登录成功，请重新执行刚才的命令", reply)
+	assert.Equal(t, client.HashPassword("right"), b.getUserConfig(1).Password)
+	token, _, err := b.storage.LoadToken(1, "18900000000")
+	require.NoError(t, err)
+	assert.Equal(t, "new-token", token)
+
+	assert.False(t, b.handleCodeReply(wrong), "登录完成后普通消息不再作为验证码")
+}
+
+func TestLoginCommandSMS(t *testing.T) {
+	b, telegram := newTestBot(t)
+	b.autodlBaseURL = newVerifyServer(t, client.SMSCodeRequiredCode).URL
+	b.userConfig[1] = &models.AutoDLConfig{Username: "18900000000", Password: client.HashPassword("right")}
+
+	reply := b.loginCommand(context.Background(), commandMessage("/login"))
+	assert.Contains(t, reply, "验证码已发送到账号绑定的手机")
+	assert.Empty(t, telegram.sent(1))
+
+	assert.Contains(t, b.codeCommand(context.Background(), commandMessage("/code")), "请在命令后附带验证码")
+	reply = b.codeCommand(context.Background(), commandMessage("/code 123456"))
+	assert.Equal(t, "AutoDL登录成功，请重新执行刚才的命令", reply)
+	token, _, err := b.storage.LoadToken(1, "18900000000")
+	require.NoError(t, err)
+	assert.Equal(t, "new-token", token, "池中的客户端完成登录并保存token")
+
+	reply = b.codeCommand(context.Background(), commandMessage("/code 123456"))
+	assert.Contains(t, reply, "当前没有等待验证码的登录")
+}
+
+func TestPendingLoginExpires(t *testing.T) {
+	pending := newPendingLogins()
+	pending.set(1, &pendingLogin{})
+	assert.True(t, pending.has(1))
+	pending.items[1].expiresAt = time.Now().Add(-time.Second)
+	assert.False(t, pending.has(1))
+	assert.Nil(t, pending.take(1))
+	assert.Nil(t, pending.take(1))
+}
@@ -0,0 +1,181 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// middleware 包装命令处理函数，在命令执行前后附加通用逻辑
+type middleware func(next commandHandler) commandHandler
+
+// chain 按顺序组合中间件，第一个中间件在最外层
+func chain(handler commandHandler, middlewares ...middleware) commandHandler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// commandPipeline 返回处理命令的完整流程：panic恢复、日志、鉴权、限流
+func (b *Bot) commandPipeline() commandHandler {
+	return chain(b.commands.dispatch,
+		b.recoverMiddleware,
+		logMiddleware,
+		b.authMiddleware,
+		b.rateLimitMiddleware,
+	)
+}
+
+// recoverMiddleware 捕获命令处理中的panic，避免更新循环退出，并通知用户和管理员
+func (b *Bot) recoverMiddleware(next commandHandler) commandHandler {
+	return func(ctx context.Context, msg *tgbotapi.Message) (reply string) {
+		defer func() {
+			if r := recover(); r != nil {
+				b.reportPanic(fmt.Sprintf("用户%d执行 /%s", msg.From.ID, msg.Command()), r)
+				reply = "命令执行出错，已通知管理员，请稍后重试"
+			}
+		}()
+		return next(ctx, msg)
+	}
+}
+
+// reportPanic 记录panic的调用栈并通知管理员
+func (b *Bot) reportPanic(what string, r interface{}) {
+	log.Printf("[ERROR] %s时发生panic: %v\n%s", what, r, debug.Stack())
+	for _, admin := range b.admins {
+		b.sendText(admin, fmt.Sprintf("%s时发生panic：%v", what, r))
+	}
+}
+
+// logMiddleware 记录每条命令的执行耗时，不记录参数以免泄露密码
+func logMiddleware(next commandHandler) commandHandler {
+	return func(ctx context.Context, msg *tgbotapi.Message) string {
+		start := time.Now()
+		reply := next(ctx, msg)
+		log.Printf("[INFO] 用户%d执行 /%s 耗时%s", msg.From.ID, msg.Command(), time.Since(start).Round(time.Millisecond))
+		return reply
+	}
+}
+
+// authMiddleware 设置了允许的用户时，只有这些用户和管理员可以使用命令
+func (b *Bot) authMiddleware(next commandHandler) commandHandler {
+	return func(ctx context.Context, msg *tgbotapi.Message) string {
+		if !b.authorized(msg.From.ID) {
+			log.Printf("[INFO] 拒绝未授权用户%d执行 /%s", msg.From.ID, msg.Command())
+			return fmt.Sprintf("你没有使用此Bot的权限，请联系管理员添加你的Telegram ID：%d", msg.From.ID)
+		}
+		return next(ctx, msg)
+	}
+}
+
+func (b *Bot) authorized(userID int64) bool {
+	if len(b.allowedUsers) == 0 || b.isAdmin(userID) {
+		return true
+	}
+	return b.allowedUsers[userID]
+}
+
+func (b *Bot) isAdmin(userID int64) bool {
+	for _, admin := range b.admins {
+		if admin == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// rateLimitMiddleware 限制每个用户执行命令的频率，管理员不受限制
+func (b *Bot) rateLimitMiddleware(next commandHandler) commandHandler {
+	return func(ctx context.Context, msg *tgbotapi.Message) string {
+		if !b.allowRate(msg.From.ID) {
+			return "操作过于频繁，请稍后再试"
+		}
+		return next(ctx, msg)
+	}
+}
+
+// allowRate 判断用户此时能否执行命令或点击按钮，两者共用同一个令牌桶
+func (b *Bot) allowRate(userID int64) bool {
+	return b.isAdmin(userID) || b.limiter.allow(userID, time.Now())
+}
+
+const (
+	// commandBurst 和 commandRefill 组成令牌桶：最多连续执行5条命令，之后每3秒恢复1条
+	commandBurst  = 5
+	commandRefill = 3 * time.Second
+	// limiterEvictInterval 是清理已恢复满令牌的限流记录的间隔
+	limiterEvictInterval = 10 * time.Minute
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter 是按用户划分的令牌桶限流器
+type rateLimiter struct {
+	mu      sync.Mutex
+	burst   float64
+	refill  time.Duration
+	buckets map[int64]*bucket
+}
+
+func newRateLimiter(burst int, refill time.Duration) *rateLimiter {
+	return &rateLimiter{
+		burst:   float64(burst),
+		refill:  refill,
+		buckets: make(map[int64]*bucket),
+	}
+}
+
+func (l *rateLimiter) allow(userID int64, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, exist := l.buckets[userID]
+	if !exist {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[userID] = b
+	}
+	b.tokens += float64(now.Sub(b.last)) / float64(l.refill)
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// evictIdle 移除已恢复满令牌的记录，这些用户下次请求时会重新创建同样的满令牌桶
+func (l *rateLimiter) evictIdle(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	full := time.Duration(l.burst * float64(l.refill))
+	for id, b := range l.buckets {
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, id)
+		}
+	}
+}
+
+func (l *rateLimiter) runEvictor(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			l.evictIdle(now)
+		case <-stop:
+			return
+		}
+	}
+}
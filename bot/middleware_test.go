@@ -0,0 +1,113 @@
+package bot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChainOrder(t *testing.T) {
+	var order []string
+	trace := func(name string) middleware {
+		return func(next commandHandler) commandHandler {
+			return func(ctx context.Context, msg *tgbotapi.Message) string {
+				order = append(order, name)
+				return next(ctx, msg)
+			}
+		}
+	}
+	handler := chain(func(ctx context.Context, msg *tgbotapi.Message) string {
+		order = append(order, "handler")
+		return "ok"
+	}, trace("outer"), trace("inner"))
+
+	assert.Equal(t, "ok", handler(context.Background(), commandMessage("/jobs")))
+	assert.Equal(t, []string{"outer", "inner", "handler"}, order)
+}
+
+func TestRecoverMiddleware(t *testing.T) {
+	b := &Bot{}
+	handler := b.recoverMiddleware(func(ctx context.Context, msg *tgbotapi.Message) string {
+		var m map[string]int
+		m["boom"]++
+		return "unreachable"
+	})
+	assert.Contains(t, handler(context.Background(), commandMessage("/jobs")), "已通知管理员")
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	b := &Bot{admins: []int64{99}, allowedUsers: map[int64]bool{1: true}}
+	handler := b.authMiddleware(func(ctx context.Context, msg *tgbotapi.Message) string {
+		return "ok"
+	})
+
+	assert.Equal(t, "ok", handler(context.Background(), commandMessage("/jobs")))
+
+	msg := commandMessage("/jobs")
+	msg.From.ID = 2
+	assert.Contains(t, handler(context.Background(), msg), "没有使用此Bot的权限")
+
+	msg.From.ID = 99
+	assert.Equal(t, "ok", handler(context.Background(), msg))
+
+	// 未设置允许的用户时不限制
+	b.allowedUsers = map[int64]bool{}
+	msg.From.ID = 2
+	assert.Equal(t, "ok", handler(context.Background(), msg))
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(2, time.Second)
+	now := time.Now()
+
+	assert.True(t, limiter.allow(1, now))
+	assert.True(t, limiter.allow(1, now))
+	assert.False(t, limiter.allow(1, now))
+	assert.True(t, limiter.allow(2, now), "每个用户单独计数")
+
+	assert.False(t, limiter.allow(1, now.Add(500*time.Millisecond)))
+	assert.True(t, limiter.allow(1, now.Add(1500*time.Millisecond)))
+	assert.True(t, limiter.allow(1, now.Add(time.Hour)))
+	assert.True(t, limiter.allow(1, now.Add(time.Hour)))
+	assert.False(t, limiter.allow(1, now.Add(time.Hour)), "令牌不超过上限")
+}
+
+func TestRateLimiterEvictIdle(t *testing.T) {
+	limiter := newRateLimiter(2, time.Second)
+	now := time.Now()
+	limiter.allow(1, now)
+	limiter.allow(2, now.Add(time.Second))
+
+	limiter.evictIdle(now.Add(2 * time.Second))
+	assert.NotContains(t, limiter.buckets, int64(1), "令牌已恢复满的记录被移除")
+	assert.Contains(t, limiter.buckets, int64(2), "令牌尚未恢复满的记录保留")
+
+	limiter.evictIdle(now.Add(time.Hour))
+	assert.Empty(t, limiter.buckets)
+	assert.True(t, limiter.allow(1, now.Add(time.Hour)))
+	assert.True(t, limiter.allow(1, now.Add(time.Hour)))
+	assert.False(t, limiter.allow(1, now.Add(time.Hour)), "移除后重新创建的令牌桶同样受限")
+}
+
+func TestCallbackRateLimit(t *testing.T) {
+	b, telegram := newTestBot(t)
+	b.limiter = newRateLimiter(1, time.Hour)
+	b.admins = []int64{99}
+	query := callbackQuery(1, 10)
+	query.Data = "unknown"
+
+	b.handleCallback(query)
+	b.handleCallback(query)
+	assert.Equal(t, []string{"按钮已失效", "操作过于频繁，请稍后再试"}, telegram.answered())
+
+	// 命令和按钮共用令牌桶
+	handler := b.rateLimitMiddleware(func(ctx context.Context, msg *tgbotapi.Message) string { return "ok" })
+	assert.Equal(t, "操作过于频繁，请稍后再试", handler(context.Background(), commandMessage("/jobs")))
+
+	query.From.ID = 99
+	b.handleCallback(query)
+	assert.Equal(t, "按钮已失效", telegram.answered()[2], "管理员不受限制")
+}
@@ -0,0 +1,152 @@
+package bot
+
+import (
+	"autodl_bot/client"
+	"autodl_bot/models"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	clientIdleTTL       = 30 * time.Minute
+	clientEvictInterval = 5 * time.Minute
+)
+
+var errNoCredentials = errors.New("请先设置AutoDL用户名和密码")
+
+type pooledClient struct {
+	client   *client.AutoDLClient
+	username string
+	password string
+	lastUsed time.Time
+}
+
+// clientPool 按Telegram用户ID缓存AutoDL客户端，每个用户使用自己的账号
+type clientPool struct {
+	mu       sync.Mutex
+	clients  map[int]*pooledClient
+	idleTTL  time.Duration
+	onCreate func(userID int, cfg models.AutoDLConfig, c *client.AutoDLClient)
+}
+
+// newClientPool 创建客户端池，onCreate 在新建客户端后、放入池之前调用，可用于恢复会话。
+// onCreate 不持有池的锁，可以进行较慢的I/O
+func newClientPool(idleTTL time.Duration, onCreate func(userID int, cfg models.AutoDLConfig, c *client.AutoDLClient)) *clientPool {
+	return &clientPool{
+		clients:  make(map[int]*pooledClient),
+		idleTTL:  idleTTL,
+		onCreate: onCreate,
+	}
+}
+
+// get 返回用户对应的客户端，不存在或凭据已变化时按配置重新创建
+func (p *clientPool) get(userID int, cfg models.AutoDLConfig) (*client.AutoDLClient, error) {
+	return p.acquire(userID, cfg, true)
+}
+
+// getBackground 供后台轮询使用，不刷新客户端的使用时间，避免有订阅的用户的客户端永远不被回收
+func (p *clientPool) getBackground(userID int, cfg models.AutoDLConfig) (*client.AutoDLClient, error) {
+	return p.acquire(userID, cfg, false)
+}
+
+// acquire 返回用户对应的客户端，touch 为true时刷新使用时间
+func (p *clientPool) acquire(userID int, cfg models.AutoDLConfig, touch bool) (*client.AutoDLClient, error) {
+	if cfg.Username == "" || cfg.Password == "" {
+		return nil, errNoCredentials
+	}
+
+	if c := p.lookup(userID, cfg, touch); c != nil {
+		return c, nil
+	}
+
+	// 在锁外创建客户端并恢复会话，避免一个用户的数据库读写阻塞其他用户
+	c := client.NewAutoDLClient(cfg.Username, cfg.Password)
+	if p.onCreate != nil {
+		p.onCreate(userID, cfg, c)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// 并发创建时保留先放入池的客户端，保证同一用户只使用一个客户端
+	if entry, exist := p.clients[userID]; exist && entry.matches(cfg) {
+		if touch {
+			entry.lastUsed = time.Now()
+		}
+		return entry.client, nil
+	}
+	// 后台创建的客户端不计为使用，用户一直不操作时会在下一次回收时移除
+	entry := &pooledClient{
+		client:   c,
+		username: cfg.Username,
+		password: cfg.Password,
+	}
+	if touch {
+		entry.lastUsed = time.Now()
+	}
+	p.clients[userID] = entry
+	return c, nil
+}
+
+// lookup 返回池中与配置一致的客户端，touch 为true时刷新使用时间，没有时返回nil
+func (p *clientPool) lookup(userID int, cfg models.AutoDLConfig, touch bool) *client.AutoDLClient {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	entry, exist := p.clients[userID]
+	if !exist || !entry.matches(cfg) {
+		return nil
+	}
+	if touch {
+		entry.lastUsed = time.Now()
+	}
+	return entry.client
+}
+
+func (e *pooledClient) matches(cfg models.AutoDLConfig) bool {
+	return e.username == cfg.Username && e.password == cfg.Password
+}
+
+// put 用已登录的客户端替换用户当前的客户端
+func (p *clientPool) put(userID int, cfg models.AutoDLConfig, c *client.AutoDLClient) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clients[userID] = &pooledClient{
+		client:   c,
+		username: cfg.Username,
+		password: cfg.Password,
+		lastUsed: time.Now(),
+	}
+}
+
+// invalidate 移除用户的客户端，下次使用时按最新配置重建
+func (p *clientPool) invalidate(userID int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.clients, userID)
+}
+
+// evictIdle 移除超过空闲时长未使用的客户端
+func (p *clientPool) evictIdle(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for id, entry := range p.clients {
+		if now.Sub(entry.lastUsed) > p.idleTTL {
+			delete(p.clients, id)
+			log.Printf("[INFO] 用户%d的AutoDL客户端空闲超时，已移除", id)
+		}
+	}
+}
+
+func (p *clientPool) runEvictor(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			p.evictIdle(now)
+		case <-stop:
+			return
+		}
+	}
+}
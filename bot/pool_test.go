@@ -0,0 +1,116 @@
+package bot
+
+import (
+	"testing"
+	"time"
+
+	"autodl_bot/client"
+	"autodl_bot/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientPoolCreatesOutsideLock(t *testing.T) {
+	release := make(chan struct{})
+	creating := make(chan int, 2)
+	var created []string
+	pool := newClientPool(time.Hour, func(userID int, cfg models.AutoDLConfig, c *client.AutoDLClient) {
+		created = append(created, cfg.Username)
+		creating <- userID
+		if userID == 1 {
+			<-release
+		}
+	})
+
+	done := make(chan *client.AutoDLClient)
+	go func() {
+		c, _ := pool.get(1, models.AutoDLConfig{Username: "user1", Password: "p"})
+		done <- c
+	}()
+	require.Equal(t, 1, <-creating)
+
+	// 用户1的客户端还在恢复会话时，其他用户可以正常获取客户端
+	other, err := pool.get(2, models.AutoDLConfig{Username: "user2", Password: "p"})
+	require.NoError(t, err)
+	require.NotNil(t, other)
+	require.Equal(t, 2, <-creating)
+
+	close(release)
+	first := <-done
+	again, err := pool.get(1, models.AutoDLConfig{Username: "user1", Password: "p"})
+	require.NoError(t, err)
+	assert.Same(t, first, again)
+	assert.Equal(t, []string{"user1", "user2"}, created, "onCreate收到的是创建客户端时的配置")
+
+	changed, err := pool.get(1, models.AutoDLConfig{Username: "user1", Password: "new"})
+	require.NoError(t, err)
+	assert.NotSame(t, first, changed, "密码变化后重新创建")
+
+	_, err = pool.get(3, models.AutoDLConfig{Username: "user3"})
+	assert.ErrorIs(t, err, errNoCredentials)
+}
+
+func TestClientPoolBackgroundDoesNotTouch(t *testing.T) {
+	pool := newClientPool(time.Minute, nil)
+	cfg := models.AutoDLConfig{Username: "user1", Password: "p"}
+
+	background, err := pool.getBackground(1, cfg)
+	require.NoError(t, err)
+	pool.evictIdle(time.Now())
+	again, err := pool.getBackground(1, cfg)
+	require.NoError(t, err)
+	assert.NotSame(t, background, again, "只被后台使用的客户端会被回收")
+
+	used, err := pool.get(1, cfg)
+	require.NoError(t, err)
+	assert.Same(t, again, used)
+	for i := 0; i < 3; i++ {
+		_, err = pool.getBackground(1, cfg)
+		require.NoError(t, err)
+	}
+	pool.evictIdle(time.Now().Add(2 * time.Minute))
+	_, exist := pool.clients[1]
+	assert.False(t, exist, "后台轮询不延长空闲时间")
+}
+
+func TestClientPoolEvictIdle(t *testing.T) {
+	pool := newClientPool(time.Minute, nil)
+	cfg1 := models.AutoDLConfig{Username: "user1", Password: "p"}
+	cfg2 := models.AutoDLConfig{Username: "user2", Password: "p"}
+	first, err := pool.get(1, cfg1)
+	require.NoError(t, err)
+	_, err = pool.get(2, cfg2)
+	require.NoError(t, err)
+	pool.clients[2].lastUsed = time.Now().Add(-2 * time.Minute)
+
+	pool.evictIdle(time.Now())
+	_, exist := pool.clients[2]
+	assert.False(t, exist, "超过空闲时长的客户端被移除")
+	again, err := pool.get(1, cfg1)
+	require.NoError(t, err)
+	assert.Same(t, first, again, "空闲时长内的客户端保留")
+
+	pool.evictIdle(time.Now().Add(2 * time.Minute))
+	assert.Empty(t, pool.clients)
+}
+
+func TestClientPoolInvalidate(t *testing.T) {
+	created := 0
+	pool := newClientPool(time.Hour, func(int, models.AutoDLConfig, *client.AutoDLClient) { created++ })
+	cfg := models.AutoDLConfig{Username: "user1", Password: "p"}
+	first, err := pool.get(1, cfg)
+	require.NoError(t, err)
+	other, err := pool.get(2, models.AutoDLConfig{Username: "user2", Password: "p"})
+	require.NoError(t, err)
+
+	pool.invalidate(1)
+	pool.invalidate(3)
+	again, err := pool.get(1, cfg)
+	require.NoError(t, err)
+	assert.NotSame(t, first, again, "移除后按配置重新创建")
+	assert.Equal(t, 3, created)
+	kept, err := pool.get(2, models.AutoDLConfig{Username: "user2", Password: "p"})
+	require.NoError(t, err)
+	assert.Same(t, other, kept, "不影响其他用户")
+}
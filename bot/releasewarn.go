@@ -0,0 +1,265 @@
+package bot
+
+import (
+	"autodl_bot/client"
+	"autodl_bot/models"
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	releaseWarnInterval = 30 * time.Minute
+	settingReleaseWarn  = "release_warn_days"
+	// releaseWarnIgnored 记录用户对实例本次关机点击了"忽略"
+	releaseWarnIgnored = 0
+)
+
+var defaultReleaseWarnDays = []int{3, 1}
+
+// releaseWarnCommand 处理 /releasewarn [天数,天数|off]，不带参数时显示当前设置
+func (b *Bot) releaseWarnCommand(msg *tgbotapi.Message) string {
+	userID := int(msg.From.ID)
+	arg := strings.TrimSpace(msg.CommandArguments())
+	switch arg {
+	case "":
+		days := b.releaseWarnDays(userID)
+		if len(days) == 0 {
+			return "释放提醒已关闭，使用 /releasewarn 3,1 开启"
+		}
+		return fmt.Sprintf("将在实例释放前 %s 天提醒", joinInts(days))
+	case "off":
+		if err := b.storage.SetSetting(userID, settingReleaseWarn, ""); err != nil {
+			log.Printf("[ERROR] 保存释放提醒设置失败: %v", err)
+			return "保存设置失败，请稍后重试"
+		}
+		return "已关闭释放提醒"
+	}
+
+	days, err := parseReleaseWarnDays(arg)
+	if err != nil {
+		return err.Error()
+	}
+	if err := b.storage.SetSetting(userID, settingReleaseWarn, joinInts(days)); err != nil {
+		log.Printf("[ERROR] 保存释放提醒设置失败: %v", err)
+		return "保存设置失败，请稍后重试"
+	}
+	return fmt.Sprintf("已设置在实例释放前 %s 天提醒", joinInts(days))
+}
+
+// releaseWarnDays 返回用户的提醒阈值（天），从大到小排列，关闭时返回空
+func (b *Bot) releaseWarnDays(userID int) []int {
+	value, exist, err := b.storage.GetSetting(userID, settingReleaseWarn)
+	if err != nil {
+		log.Printf("[ERROR] 读取释放提醒设置失败: %v", err)
+	}
+	if !exist {
+		return defaultReleaseWarnDays
+	}
+	if value == "" {
+		return nil
+	}
+	days, err := parseReleaseWarnDays(value)
+	if err != nil {
+		return defaultReleaseWarnDays
+	}
+	return days
+}
+
+func parseReleaseWarnDays(value string) ([]int, error) {
+	maxDays := int(client.ReleaseAfter / (24 * time.Hour))
+	var days []int
+	for _, field := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || n < 1 || n >= maxDays {
+			return nil, fmt.Errorf("提醒天数必须是1到%d之间的整数，多个用逗号分隔，例如：/releasewarn 3,1", maxDays-1)
+		}
+		if !containsInt(days, n) {
+			days = append(days, n)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(days)))
+	return days, nil
+}
+
+// runReleaseWarner 定期检查已关机实例的释放时间，直到stop关闭
+func (b *Bot) runReleaseWarner(stop <-chan struct{}) {
+	ticker := time.NewTicker(releaseWarnInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.checkReleaseWarnings()
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (b *Bot) checkReleaseWarnings() {
+	for _, userID := range b.configuredUsers() {
+		days := b.releaseWarnDays(userID)
+		if len(days) == 0 {
+			continue
+		}
+		autodl, err := b.backgroundClient(userID)
+		if err != nil {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+		instances, err := autodl.ListAllInstancesContext(ctx, client.InstanceFilter{Status: []string{models.StatusShutdown}})
+		cancel()
+		if err != nil {
+			log.Printf("[ERROR] 用户%d的释放提醒查询实例失败: %v", userID, err)
+			continue
+		}
+
+		for _, instance := range instances {
+			b.warnRelease(userID, instance, days)
+		}
+	}
+}
+
+// warnRelease 在实例进入某个提醒阈值时发送一次提醒，同一次关机的每个阈值只提醒一次
+func (b *Bot) warnRelease(userID int, instance models.Instance, days []int) {
+	if instance.Status != models.StatusShutdown {
+		return
+	}
+	releaseAt, ok := client.ReleaseTime(instance)
+	if !ok {
+		return
+	}
+	left := time.Until(releaseAt)
+	if left <= 0 {
+		return
+	}
+
+	threshold := releaseWarnThreshold(left, days)
+	if threshold == 0 {
+		return
+	}
+
+	stoppedKey := releaseWarnKey(releaseAt)
+	ignored, err := b.storage.HasReleaseWarning(userID, instance.UUID, stoppedKey, releaseWarnIgnored)
+	if err != nil {
+		log.Printf("[ERROR] 读取释放提醒记录失败: %v", err)
+		return
+	}
+	if ignored {
+		return
+	}
+	// 先记录已经错过的更大阈值，避免之后补发；记录失败时不发送，下次检查时重试
+	for _, d := range days {
+		if d <= threshold {
+			continue
+		}
+		if _, err := b.storage.MarkReleaseWarning(userID, instance.UUID, stoppedKey, d); err != nil {
+			log.Printf("[ERROR] 记录释放提醒失败: %v", err)
+			return
+		}
+	}
+	marked, err := b.storage.MarkReleaseWarning(userID, instance.UUID, stoppedKey, threshold)
+	if err != nil {
+		log.Printf("[ERROR] 记录释放提醒失败: %v", err)
+		return
+	}
+	if !marked {
+		return
+	}
+
+	text := fmt.Sprintf("释放提醒：实例 %s-%s（%s）将在%s后释放（%s），请及时刷新或备份数据",
+		instance.RegionName, instance.MachineAlias, instance.UUID,
+		formatDays(left), releaseAt.Format("2006-01-02 15:04"))
+	msg := tgbotapi.NewMessage(int64(userID), text)
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("立即刷新", b.callbackData(int64(userID), actionRefresh, instance.UUID)),
+		tgbotapi.NewInlineKeyboardButtonData("忽略", b.callbackData(int64(userID), actionIgnoreRelease, instance.UUID, stoppedKey)),
+	))
+	if _, err := b.api.Send(msg); err != nil {
+		log.Printf("error sending message: %v", err)
+	}
+}
+
+// releaseWarnThreshold 返回剩余时间已进入的最小提醒阈值（天），days从大到小排列，未进入任何阈值时返回0
+func releaseWarnThreshold(left time.Duration, days []int) int {
+	threshold := 0
+	for _, d := range days {
+		if left <= time.Duration(d)*24*time.Hour {
+			threshold = d
+		}
+	}
+	return threshold
+}
+
+// refreshCallback 处理"立即刷新"按钮
+func (b *Bot) refreshCallback(query *tgbotapi.CallbackQuery, args []string) string {
+	if len(args) != 1 {
+		return "参数错误"
+	}
+	uuid := args[0]
+	userID := int(query.From.ID)
+	autodl, err := b.autodlClient(userID)
+	if err != nil {
+		return errorReply(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+	if err := autodl.PowerOnContext(ctx, uuid, true); err != nil {
+		b.sendText(query.Message.Chat.ID, errorReply(err))
+		return "刷新失败"
+	}
+	b.removeKeyboard(query.Message)
+	b.sendText(query.Message.Chat.ID, b.refreshReply(userID, query.Message.Chat.ID, uuid))
+	return "开始刷新"
+}
+
+// ignoreReleaseCallback 处理"忽略"按钮，本次关机不再提醒
+func (b *Bot) ignoreReleaseCallback(query *tgbotapi.CallbackQuery, args []string) string {
+	if len(args) != 2 {
+		return "参数错误"
+	}
+	if _, err := b.storage.MarkReleaseWarning(int(query.From.ID), args[0], args[1], releaseWarnIgnored); err != nil {
+		log.Printf("[ERROR] 记录忽略释放提醒失败: %v", err)
+		return "操作失败，请稍后重试"
+	}
+	b.removeKeyboard(query.Message)
+	return "已忽略，本次关机不再提醒"
+}
+
+// releaseWarnKey 用关机时间标识实例的某一次关机，实例重新开关机后会重新提醒
+func releaseWarnKey(releaseAt time.Time) string {
+	return strconv.FormatInt(releaseAt.Add(-client.ReleaseAfter).Unix(), 10)
+}
+
+func formatDays(d time.Duration) string {
+	days := int(d / (24 * time.Hour))
+	hours := int(d % (24 * time.Hour) / time.Hour)
+	if days > 0 {
+		return fmt.Sprintf("%d天%d小时", days, hours)
+	}
+	return fmt.Sprintf("%d小时", hours)
+}
+
+func joinInts(values []int) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = strconv.Itoa(v)
+	}
+	return strings.Join(parts, ",")
+}
+
+func containsInt(values []int, n int) bool {
+	for _, v := range values {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package bot
+
+import (
+	"testing"
+	"time"
+
+	"autodl_bot/client"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReleaseWarnThreshold(t *testing.T) {
+	day := 24 * time.Hour
+	tests := []struct {
+		name string
+		left time.Duration
+		days []int
+		want int
+	}{
+		{name: "未进入任何阈值", left: 5 * day, days: []int{3, 1}, want: 0},
+		{name: "刚好超过最大阈值", left: 3*day + time.Second, days: []int{3, 1}, want: 0},
+		{name: "刚好等于最大阈值", left: 3 * day, days: []int{3, 1}, want: 3},
+		{name: "两个阈值之间", left: 2 * day, days: []int{3, 1}, want: 3},
+		{name: "进入最小阈值", left: 12 * time.Hour, days: []int{3, 1}, want: 1},
+		{name: "同时进入多个阈值时取最小", left: time.Hour, days: []int{7, 3, 1}, want: 1},
+		{name: "只有一个阈值", left: 4 * day, days: []int{5}, want: 5},
+		{name: "没有阈值", left: time.Hour, days: nil, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, releaseWarnThreshold(tt.left, tt.days))
+		})
+	}
+}
+
+func TestWarnRelease(t *testing.T) {
+	b, telegram := newTestBot(t)
+	days := []int{3, 1}
+	now := time.Now()
+
+	soon := stoppedInstance("soon", now.Add(2*24*time.Hour-client.ReleaseAfter))
+	b.warnRelease(1, soon, days)
+	b.warnRelease(1, soon, days)
+	sent := telegram.sent(1)
+	require.Len(t, sent, 1, "同一阈值只提醒一次")
+	assert.Contains(t, sent[0], "实例 -（soon）将在1天")
+
+	// 直接进入1天阈值时，已错过的3天阈值不再补发
+	urgent := stoppedInstance("urgent", now.Add(12*time.Hour-client.ReleaseAfter))
+	b.warnRelease(1, urgent, days)
+	releaseAt, _ := client.ReleaseTime(urgent)
+	key := releaseWarnKey(releaseAt)
+	skipped, err := b.storage.HasReleaseWarning(1, "urgent", key, 3)
+	require.NoError(t, err)
+	assert.True(t, skipped)
+	require.Len(t, telegram.sent(1), 2)
+
+	ignored := stoppedInstance("ignored", now.Add(12*time.Hour-client.ReleaseAfter))
+	releaseAt, _ = client.ReleaseTime(ignored)
+	_, err = b.storage.MarkReleaseWarning(1, "ignored", releaseWarnKey(releaseAt), releaseWarnIgnored)
+	require.NoError(t, err)
+	b.warnRelease(1, ignored, days)
+	assert.Len(t, telegram.sent(1), 2, "忽略后本次关机不再提醒")
+
+	b.warnRelease(1, stoppedInstance("later", now.Add(5*24*time.Hour-client.ReleaseAfter)), days)
+	assert.Len(t, telegram.sent(1), 2)
+}
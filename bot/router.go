@@ -0,0 +1,128 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// commandHandler 处理一条命令并返回回复文本
+type commandHandler func(ctx context.Context, msg *tgbotapi.Message) string
+
+// commandArg 描述命令的一个参数，用于生成用法说明和检查必填参数
+type commandArg struct {
+	name     string
+	optional bool
+}
+
+func arg(name string) commandArg    { return commandArg{name: name} }
+func optArg(name string) commandArg { return commandArg{name: name, optional: true} }
+func optArgs(names ...string) []commandArg {
+	args := make([]commandArg, len(names))
+	for i, name := range names {
+		args[i] = optArg(name)
+	}
+	return args
+}
+
+// command 是注册到路由中的一条命令，/help 和Telegram命令菜单都由它生成
+type command struct {
+	name        string
+	description string // 命令菜单中的简短说明
+	args        []commandArg
+	example     string // /help 中的示例，可为空
+	handler     commandHandler
+}
+
+// usage 返回命令的用法，例如：/start <uuid> [--for 时长]
+func (c *command) usage() string {
+	parts := []string{"/" + c.name}
+	for _, a := range c.args {
+		if a.optional {
+			parts = append(parts, "["+a.name+"]")
+		} else {
+			parts = append(parts, "<"+a.name+">")
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func (c *command) requiredArgs() int {
+	n := 0
+	for _, a := range c.args {
+		if !a.optional {
+			n++
+		}
+	}
+	return n
+}
+
+type router struct {
+	commands []*command
+	byName   map[string]*command
+}
+
+func newRouter() *router {
+	return &router{byName: make(map[string]*command)}
+}
+
+// register 按顺序注册命令，/help 和命令菜单保持注册顺序
+func (r *router) register(cmd command) {
+	if _, exist := r.byName[cmd.name]; exist {
+		panic(fmt.Sprintf("重复注册命令：%s", cmd.name))
+	}
+	r.commands = append(r.commands, &cmd)
+	r.byName[cmd.name] = &cmd
+}
+
+func (r *router) lookup(name string) (*command, bool) {
+	cmd, exist := r.byName[name]
+	return cmd, exist
+}
+
+// dispatch 检查必填参数后执行命令，返回回复文本
+func (r *router) dispatch(ctx context.Context, msg *tgbotapi.Message) string {
+	cmd, exist := r.lookup(msg.Command())
+	if !exist {
+		return "未知命令，请使用 /help 查看支持的命令"
+	}
+	if len(strings.Fields(msg.CommandArguments())) < cmd.requiredArgs() {
+		reply := "用法：" + cmd.usage()
+		if cmd.example != "" {
+			reply += "\n例如：" + cmd.example
+		}
+		return reply
+	}
+	return cmd.handler(ctx, msg)
+}
+
+// botCommands 生成Telegram命令菜单
+func (r *router) botCommands() []tgbotapi.BotCommand {
+	commands := make([]tgbotapi.BotCommand, len(r.commands))
+	for i, cmd := range r.commands {
+		commands[i] = tgbotapi.BotCommand{Command: cmd.name, Description: cmd.description}
+	}
+	return commands
+}
+
+// helpText 生成 /help 的回复
+func (r *router) helpText() string {
+	var sb strings.Builder
+	sb.WriteString("支持的命令：")
+	for _, cmd := range r.commands {
+		sb.WriteString("\n" + cmd.usage() + " - " + cmd.description)
+		if cmd.example != "" {
+			sb.WriteString("，例如：" + cmd.example)
+		}
+	}
+	return sb.String()
+}
+
+// plain 把不需要context的命令处理函数转换为commandHandler
+func plain(handler func(msg *tgbotapi.Message) string) commandHandler {
+	return func(ctx context.Context, msg *tgbotapi.Message) string {
+		return handler(msg)
+	}
+}
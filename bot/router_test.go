@@ -0,0 +1,54 @@
+package bot
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func commandMessage(text string) *tgbotapi.Message {
+	name, _, _ := strings.Cut(strings.TrimPrefix(text, "/"), " ")
+	return &tgbotapi.Message{
+		Text:     text,
+		From:     &tgbotapi.User{ID: 1},
+		Chat:     &tgbotapi.Chat{ID: 1},
+		Entities: []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(name) + 1}},
+	}
+}
+
+func TestRouterDispatch(t *testing.T) {
+	r := newRouter()
+	r.register(command{
+		name:        "stop",
+		description: "关闭GPU实例",
+		args:        []commandArg{arg("uuid"), optArg("--force")},
+		example:     "/stop xx-yy",
+		handler: func(ctx context.Context, msg *tgbotapi.Message) string {
+			return "stopping " + msg.CommandArguments()
+		},
+	})
+
+	assert.Equal(t, "stopping xx-yy", r.dispatch(context.Background(), commandMessage("/stop xx-yy")))
+	assert.Equal(t, "用法：/stop <uuid> [--force]\n例如：/stop xx-yy", r.dispatch(context.Background(), commandMessage("/stop")))
+	assert.Contains(t, r.dispatch(context.Background(), commandMessage("/unknown")), "未知命令")
+
+	assert.Equal(t, []tgbotapi.BotCommand{{Command: "stop", Description: "关闭GPU实例"}}, r.botCommands())
+	assert.Equal(t, "支持的命令：\n/stop <uuid> [--force] - 关闭GPU实例，例如：/stop xx-yy", r.helpText())
+	assert.Panics(t, func() { r.register(command{name: "stop"}) })
+}
+
+// TestReadmeDocumentsCommands 防止README与注册的命令再次不一致
+func TestReadmeDocumentsCommands(t *testing.T) {
+	readme, err := os.ReadFile("../readme.md")
+	assert.NoError(t, err)
+
+	b := &Bot{}
+	for _, cmd := range b.registerCommands().commands {
+		assert.Contains(t, string(readme), "`/"+cmd.name, "README缺少命令 /%s", cmd.name)
+		assert.NotEmpty(t, cmd.description, cmd.name)
+	}
+}
@@ -0,0 +1,209 @@
+package bot
+
+import (
+	"autodl_bot/client"
+	"autodl_bot/models"
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	runRemindInterval        = 5 * time.Minute
+	settingRunRemind         = "running_remind_hours"
+	defaultRunRemindHours    = 8
+	maxRunRemindHours        = 24 * 7
+	runRemindRestartTolerate = time.Minute
+)
+
+// runRemindCommand 处理 /runremind [小时|off]，不带参数时显示当前设置
+func (b *Bot) runRemindCommand(msg *tgbotapi.Message) string {
+	userID := int(msg.From.ID)
+	arg := strings.TrimSpace(msg.CommandArguments())
+	switch arg {
+	case "":
+		hours := b.runRemindHours(userID)
+		if hours == 0 {
+			return "长时间运行提醒已关闭，使用 /runremind 8 开启"
+		}
+		return fmt.Sprintf("实例连续运行%d小时后提醒", hours)
+	case "off":
+		if err := b.storage.SetSetting(userID, settingRunRemind, ""); err != nil {
+			log.Printf("[ERROR] 保存运行提醒设置失败: %v", err)
+			return "保存设置失败，请稍后重试"
+		}
+		return "已关闭长时间运行提醒"
+	}
+
+	hours, err := strconv.Atoi(arg)
+	if err != nil || hours < 1 || hours > maxRunRemindHours {
+		return fmt.Sprintf("小时数必须是1到%d之间的整数，例如：/runremind 8", maxRunRemindHours)
+	}
+	if err := b.storage.SetSetting(userID, settingRunRemind, strconv.Itoa(hours)); err != nil {
+		log.Printf("[ERROR] 保存运行提醒设置失败: %v", err)
+		return "保存设置失败，请稍后重试"
+	}
+	return fmt.Sprintf("已设置实例连续运行%d小时后提醒", hours)
+}
+
+// runRemindHours 返回用户设置的提醒小时数，关闭时返回0
+func (b *Bot) runRemindHours(userID int) int {
+	value, exist, err := b.storage.GetSetting(userID, settingRunRemind)
+	if err != nil {
+		log.Printf("[ERROR] 读取运行提醒设置失败: %v", err)
+	}
+	if !exist {
+		return defaultRunRemindHours
+	}
+	if value == "" {
+		return 0
+	}
+	hours, err := strconv.Atoi(value)
+	if err != nil {
+		return defaultRunRemindHours
+	}
+	return hours
+}
+
+// runRunningMonitor 定期记录运行中的实例并提醒运行过久的实例，直到stop关闭
+func (b *Bot) runRunningMonitor(stop <-chan struct{}) {
+	ticker := time.NewTicker(runRemindInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.checkRunningInstances()
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (b *Bot) checkRunningInstances() {
+	for _, userID := range b.configuredUsers() {
+		hours := b.runRemindHours(userID)
+		if hours == 0 {
+			continue
+		}
+		autodl, err := b.backgroundClient(userID)
+		if err != nil {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+		instances, err := autodl.ListAllInstancesContext(ctx, client.InstanceFilter{Status: []string{models.StatusRunning}})
+		cancel()
+		if err != nil {
+			log.Printf("[ERROR] 用户%d的运行提醒查询实例失败: %v", userID, err)
+			continue
+		}
+
+		records, err := b.storage.ListRunningInstances(userID)
+		if err != nil {
+			log.Printf("[ERROR] 读取实例运行记录失败: %v", err)
+			continue
+		}
+		known := make(map[string]*models.RunningInstance, len(records))
+		for _, record := range records {
+			known[record.UUID] = record
+		}
+
+		running := make(map[string]bool)
+		for _, instance := range instances {
+			if instance.Status != models.StatusRunning {
+				continue
+			}
+			running[instance.UUID] = true
+			b.trackRunning(userID, instance, known[instance.UUID], time.Duration(hours)*time.Hour)
+		}
+		for uuid := range known {
+			if !running[uuid] {
+				if err := b.storage.DeleteRunningInstance(userID, uuid); err != nil {
+					log.Printf("[ERROR] 删除实例运行记录失败: %v", err)
+				}
+			}
+		}
+	}
+}
+
+// trackRunning 记录实例开始运行的时间，运行满remindAfter后提醒，之后每隔remindAfter再提醒
+func (b *Bot) trackRunning(userID int, instance models.Instance, record *models.RunningInstance, remindAfter time.Duration) {
+	since, ok := client.StartedTime(instance)
+	// 两次检查之间实例重新开过机，按新的一次运行计算。接口没有返回开机时间时无法判断是否重新开过机，
+	// 沿用已有的记录，首次发现时从现在开始计时
+	if record == nil || ok && since.After(record.Since.Add(runRemindRestartTolerate)) {
+		if !ok {
+			since = time.Now()
+		}
+		record = &models.RunningInstance{TelegramID: userID, UUID: instance.UUID, Since: since}
+		if err := b.storage.SaveRunningInstance(record); err != nil {
+			log.Printf("[ERROR] 保存实例运行记录失败: %v", err)
+			return
+		}
+	}
+
+	last := record.Since
+	if record.RemindedAt.After(last) {
+		last = record.RemindedAt
+	}
+	if time.Since(last) < remindAfter {
+		return
+	}
+	record.RemindedAt = time.Now()
+	if err := b.storage.SaveRunningInstance(record); err != nil {
+		log.Printf("[ERROR] 保存实例运行记录失败: %v", err)
+		return
+	}
+	b.remindRunning(userID, instance, time.Since(record.Since))
+}
+
+func (b *Bot) remindRunning(userID int, instance models.Instance, elapsed time.Duration) {
+	text := fmt.Sprintf("实例 %s-%s（%s）已运行%s", instance.RegionName, instance.MachineAlias, instance.UUID, formatDays(elapsed))
+	if instance.ChargeType == models.ChargeTypePayg {
+		text += fmt.Sprintf("（≈¥%.2f）", client.HourlyPrice(instance)*elapsed.Hours())
+	}
+	text += "，还需要吗？"
+
+	msg := tgbotapi.NewMessage(int64(userID), text)
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("关机", b.callbackData(int64(userID), actionStopInstance, instance.UUID)),
+		tgbotapi.NewInlineKeyboardButtonData("保留", b.callbackData(int64(userID), actionKeepRunning, instance.UUID)),
+	))
+	if _, err := b.api.Send(msg); err != nil {
+		log.Printf("error sending message: %v", err)
+	}
+}
+
+// stopInstanceCallback 处理"关机"按钮
+func (b *Bot) stopInstanceCallback(query *tgbotapi.CallbackQuery, args []string) string {
+	if len(args) != 1 {
+		return "参数错误"
+	}
+	uuid := args[0]
+	userID := int(query.From.ID)
+	autodl, err := b.autodlClient(userID)
+	if err != nil {
+		return errorReply(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+	if err := autodl.PowerOffContext(ctx, uuid); err != nil {
+		b.sendText(query.Message.Chat.ID, errorReply(err))
+		return "关机失败"
+	}
+	b.removeKeyboard(query.Message)
+	b.sendText(query.Message.Chat.ID, fmt.Sprintf("实例 %s 正在关机…", uuid))
+	go b.reportStatus(query.Message.Chat.ID, autodl, uuid, models.StatusShutdown, time.Now())
+	return "正在关机"
+}
+
+// keepRunningCallback 处理"保留"按钮，下一个提醒周期后再提醒
+func (b *Bot) keepRunningCallback(query *tgbotapi.CallbackQuery, args []string) string {
+	b.removeKeyboard(query.Message)
+	return fmt.Sprintf("好的，%d小时后再提醒", b.runRemindHours(int(query.From.ID)))
+}
@@ -0,0 +1,93 @@
+package bot
+
+import (
+	"testing"
+	"time"
+
+	"autodl_bot/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func runningInstance(startedAt time.Time) models.Instance {
+	instance := models.Instance{UUID: "xx-yy", Status: models.StatusRunning}
+	if !startedAt.IsZero() {
+		instance.StartedAt = models.NullTime{Time: startedAt.Format(time.RFC3339), Valid: true}
+	}
+	return instance
+}
+
+func runningRecord(t *testing.T, b *Bot) *models.RunningInstance {
+	records, err := b.storage.ListRunningInstances(1)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	return records[0]
+}
+
+func TestTrackRunning(t *testing.T) {
+	const remindAfter = 8 * time.Hour
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		record    *models.RunningInstance // 上次检查保存的记录
+		startedAt time.Time               // 接口返回的开机时间，零值表示没有返回
+		since     time.Time               // 检查后记录的开始运行时间
+		reminded  bool
+	}{
+		{name: "首次发现且没有开机时间", since: now},
+		{name: "首次发现且已运行较久", startedAt: now.Add(-9 * time.Hour), since: now.Add(-9 * time.Hour), reminded: true},
+		{name: "没有开机时间时沿用记录",
+			record: &models.RunningInstance{Since: now.Add(-9 * time.Hour)},
+			since:  now.Add(-9 * time.Hour), reminded: true},
+		{name: "没有开机时间且未到提醒时间",
+			record: &models.RunningInstance{Since: now.Add(-2 * time.Hour)},
+			since:  now.Add(-2 * time.Hour)},
+		{name: "重新开机后重新计时",
+			record:    &models.RunningInstance{Since: now.Add(-9 * time.Hour), RemindedAt: now.Add(-time.Hour)},
+			startedAt: now.Add(-10 * time.Minute), since: now.Add(-10 * time.Minute)},
+		{name: "持续运行满提醒间隔",
+			record:    &models.RunningInstance{Since: now.Add(-9 * time.Hour)},
+			startedAt: now.Add(-9 * time.Hour), since: now.Add(-9 * time.Hour), reminded: true},
+		{name: "持续运行且刚提醒过",
+			record:    &models.RunningInstance{Since: now.Add(-9 * time.Hour), RemindedAt: now.Add(-time.Hour)},
+			startedAt: now.Add(-9 * time.Hour), since: now.Add(-9 * time.Hour)},
+		{name: "开机时间有少量误差不算重新开机",
+			record:    &models.RunningInstance{Since: now.Add(-17 * time.Hour), RemindedAt: now.Add(-9 * time.Hour)},
+			startedAt: now.Add(-17*time.Hour + 30*time.Second), since: now.Add(-17 * time.Hour), reminded: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, telegram := newTestBot(t)
+			if tt.record != nil {
+				tt.record.TelegramID, tt.record.UUID = 1, "xx-yy"
+				require.NoError(t, b.storage.SaveRunningInstance(tt.record))
+			}
+
+			b.trackRunning(1, runningInstance(tt.startedAt), tt.record, remindAfter)
+
+			record := runningRecord(t, b)
+			assert.WithinDuration(t, tt.since, record.Since, 2*time.Second)
+			if tt.reminded {
+				require.Len(t, telegram.sent(1), 1)
+				assert.Contains(t, telegram.sent(1)[0], "还需要吗")
+				assert.WithinDuration(t, time.Now(), record.RemindedAt, 2*time.Second)
+			} else {
+				assert.Empty(t, telegram.sent(1))
+			}
+		})
+	}
+}
+
+func TestTrackRunningRemindsOncePerInterval(t *testing.T) {
+	b, telegram := newTestBot(t)
+	instance := runningInstance(time.Time{})
+	record := &models.RunningInstance{TelegramID: 1, UUID: "xx-yy", Since: time.Now().Add(-9 * time.Hour)}
+	require.NoError(t, b.storage.SaveRunningInstance(record))
+
+	for i := 0; i < 3; i++ {
+		b.trackRunning(1, instance, runningRecord(t, b), 8*time.Hour)
+	}
+	assert.Len(t, telegram.sent(1), 1, "没有开机时间的实例不会因为每次检查被当作重新开机")
+}
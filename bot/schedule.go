@@ -0,0 +1,202 @@
+package bot
+
+import (
+	"autodl_bot/models"
+	"autodl_bot/scheduler"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	scheduleInterval = time.Minute
+	// scheduleStartGrace 是错过的定时开机仍然执行的最长延迟，Bot停机太久后不再补开机，避免意外计费；
+	// 错过的定时关机总是补执行
+	scheduleStartGrace = 30 * time.Minute
+)
+
+// 定时计划的动作
+const (
+	scheduleStart    = "start"
+	scheduleStartCPU = "startcpu"
+	scheduleStop     = "stop"
+)
+
+var scheduleActionNames = map[string]string{
+	scheduleStart:    "开机",
+	scheduleStartCPU: "无卡模式开机",
+	scheduleStop:     "关机",
+}
+
+// scheduleCommand 处理 /schedule <uuid> start|startcpu|stop "<cron表达式>"，不带参数时列出计划
+func (b *Bot) scheduleCommand(msg *tgbotapi.Message) string {
+	userID := int(msg.From.ID)
+	fields := strings.Fields(msg.CommandArguments())
+	if len(fields) == 0 {
+		return b.listSchedules(userID)
+	}
+	if len(fields) < 3 {
+		return `用法：/schedule xx-yy start|startcpu|stop "0 9 * * 1-5"`
+	}
+	uuid, action := fields[0], fields[1]
+	if _, ok := scheduleActionNames[action]; !ok {
+		return "动作必须是 start、startcpu 或 stop"
+	}
+	// Telegram客户端可能把引号替换成中文引号
+	spec := strings.Trim(strings.Join(fields[2:], " "), "\"'“”")
+	cron, err := scheduler.ParseCron(spec)
+	if err != nil {
+		return err.Error()
+	}
+	if _, err := b.autodlClient(userID); err != nil {
+		return errorReply(err)
+	}
+
+	loc := b.userLocation(userID)
+	next := cron.Next(time.Now().In(loc))
+	if next.IsZero() {
+		return "该cron表达式不会触发，请检查日期和月份"
+	}
+	schedule := &models.Schedule{
+		TelegramID: userID,
+		ChatID:     msg.Chat.ID,
+		UUID:       uuid,
+		Action:     action,
+		Spec:       cron.String(),
+		NextRun:    next,
+	}
+	id, err := b.storage.AddSchedule(schedule)
+	if err != nil {
+		log.Printf("[ERROR] 保存定时计划失败: %v", err)
+		return "保存定时计划失败，请稍后重试"
+	}
+	return fmt.Sprintf("已创建定时计划 #%d：实例 %s 按「%s」%s（时区 %s），下次执行 %s",
+		id, uuid, schedule.Spec, scheduleActionNames[action], loc, next.Format("2006-01-02 15:04"))
+}
+
+// unscheduleCommand 处理 /unschedule <计划ID>
+func (b *Bot) unscheduleCommand(msg *tgbotapi.Message) string {
+	id, err := strconv.ParseInt(strings.TrimPrefix(strings.TrimSpace(msg.CommandArguments()), "#"), 10, 64)
+	if err != nil {
+		return "请在命令后附带计划ID，例如：/unschedule 3"
+	}
+	n, err := b.storage.DeleteSchedule(int(msg.From.ID), id)
+	if err != nil {
+		log.Printf("[ERROR] 删除定时计划失败: %v", err)
+		return "删除定时计划失败，请稍后重试"
+	}
+	if n == 0 {
+		return "没有找到对应的计划，请使用 /schedule 查看"
+	}
+	return fmt.Sprintf("已删除定时计划 #%d", id)
+}
+
+func (b *Bot) listSchedules(userID int) string {
+	schedules, err := b.storage.ListSchedules(userID)
+	if err != nil {
+		log.Printf("[ERROR] 读取定时计划失败: %v", err)
+		return "读取定时计划失败，请稍后重试"
+	}
+	if len(schedules) == 0 {
+		return `当前没有定时计划，使用 /schedule xx-yy start "0 9 * * 1-5" 创建`
+	}
+	loc := b.userLocation(userID)
+	reply := fmt.Sprintf("定时计划（时区 %s）：\n", loc)
+	for _, schedule := range schedules {
+		reply += fmt.Sprintf("#%d %s %s「%s」下次 %s\n", schedule.ID, schedule.UUID,
+			scheduleActionNames[schedule.Action], schedule.Spec, schedule.NextRun.In(loc).Format("01-02 15:04"))
+	}
+	return reply
+}
+
+// runSchedules 每分钟检查到期的定时计划，直到stop关闭
+func (b *Bot) runSchedules(stop <-chan struct{}) {
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.checkSchedules()
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (b *Bot) checkSchedules() {
+	schedules, err := b.storage.ListSchedules(0)
+	if err != nil {
+		log.Printf("[ERROR] 读取定时计划失败: %v", err)
+		return
+	}
+	now := time.Now()
+	for _, schedule := range schedules {
+		if schedule.NextRun.After(now) {
+			continue
+		}
+		// 先推进下次执行时间，开关机交给后台任务执行和重试，保证每个时间点只触发一次
+		if !b.advanceSchedule(schedule) {
+			continue
+		}
+		if schedule.Action != scheduleStop && now.Sub(schedule.NextRun) > scheduleStartGrace {
+			b.sendText(schedule.ChatID, fmt.Sprintf("定时计划 #%d 错过了 %s 的开机时间，本次不再执行",
+				schedule.ID, schedule.NextRun.In(b.userLocation(schedule.TelegramID)).Format("01-02 15:04")))
+			continue
+		}
+		b.fireSchedule(schedule)
+	}
+}
+
+// advanceSchedule 按用户时区计算并保存计划的下次执行时间
+func (b *Bot) advanceSchedule(schedule *models.Schedule) bool {
+	cron, err := scheduler.ParseCron(schedule.Spec)
+	if err != nil {
+		log.Printf("[ERROR] 定时计划 #%d 的表达式无效: %v", schedule.ID, err)
+		return false
+	}
+	next := cron.Next(time.Now().In(b.userLocation(schedule.TelegramID)))
+	if next.IsZero() {
+		// 不会再触发的计划推迟到很久以后，避免每分钟重复执行
+		next = time.Now().AddDate(100, 0, 0)
+	}
+	if err := b.storage.SetScheduleNextRun(schedule.ID, next); err != nil {
+		log.Printf("[ERROR] 更新定时计划 #%d 失败: %v", schedule.ID, err)
+		return false
+	}
+	return true
+}
+
+func (b *Bot) fireSchedule(schedule *models.Schedule) {
+	job := &models.Job{
+		TelegramID: schedule.TelegramID,
+		ChatID:     schedule.ChatID,
+		Kind:       jobPowerOn,
+		UUID:       schedule.UUID,
+	}
+	switch schedule.Action {
+	case scheduleStartCPU:
+		job.Payload = payloadCPU
+	case scheduleStop:
+		job.Kind = jobPowerOff
+	}
+	if _, err := b.scheduler.Schedule(job); err != nil {
+		log.Printf("[ERROR] 定时计划 #%d 创建任务失败: %v", schedule.ID, err)
+		b.sendText(schedule.ChatID, fmt.Sprintf("定时计划 #%d 执行失败，请稍后重试", schedule.ID))
+	}
+}
+
+// rescheduleUser 在用户修改时区后重新计算其所有计划的下次执行时间
+func (b *Bot) rescheduleUser(userID int) {
+	schedules, err := b.storage.ListSchedules(userID)
+	if err != nil {
+		log.Printf("[ERROR] 读取定时计划失败: %v", err)
+		return
+	}
+	for _, schedule := range schedules {
+		b.advanceSchedule(schedule)
+	}
+}
@@ -0,0 +1,131 @@
+package bot
+
+import (
+	"testing"
+	"time"
+
+	"autodl_bot/models"
+	"autodl_bot/scheduler"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// addSchedule 保存一个每天9点执行的计划，下次执行时间为nextRun
+func addSchedule(t *testing.T, b *Bot, action string, nextRun time.Time) *models.Schedule {
+	schedule := &models.Schedule{TelegramID: 1, ChatID: 10, UUID: "xx-yy", Action: action, Spec: "0 9 * * *", NextRun: nextRun}
+	id, err := b.storage.AddSchedule(schedule)
+	require.NoError(t, err)
+	schedule.ID = id
+	return schedule
+}
+
+func TestCheckSchedules(t *testing.T) {
+	tests := []struct {
+		name    string
+		action  string
+		late    time.Duration // 负数表示尚未到期
+		kind    string        // 为空表示不创建任务
+		payload string
+		missed  bool
+	}{
+		{name: "未到期", action: scheduleStart, late: -time.Minute},
+		{name: "按时开机", action: scheduleStart, late: time.Second, kind: jobPowerOn},
+		{name: "按时无卡模式开机", action: scheduleStartCPU, late: time.Second, kind: jobPowerOn, payload: payloadCPU},
+		{name: "按时关机", action: scheduleStop, late: time.Second, kind: jobPowerOff},
+		{name: "停机后在宽限期内补开机", action: scheduleStart, late: scheduleStartGrace - time.Minute, kind: jobPowerOn},
+		{name: "停机超过宽限期不补开机", action: scheduleStart, late: scheduleStartGrace + time.Minute, missed: true},
+		{name: "停机超过宽限期仍补关机", action: scheduleStop, late: 3 * time.Hour, kind: jobPowerOff},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, telegram := newTestBot(t)
+			nextRun := time.Now().Add(-tt.late).Truncate(time.Second)
+			addSchedule(t, b, tt.action, nextRun)
+
+			b.checkSchedules()
+
+			jobs, err := b.scheduler.List(1)
+			require.NoError(t, err)
+			if tt.kind == "" {
+				assert.Empty(t, jobs)
+			} else {
+				require.Len(t, jobs, 1)
+				assert.Equal(t, tt.kind, jobs[0].Kind)
+				assert.Equal(t, tt.payload, jobs[0].Payload)
+				assert.Equal(t, "xx-yy", jobs[0].UUID)
+				assert.Equal(t, int64(10), jobs[0].ChatID)
+			}
+			if tt.missed {
+				sent := telegram.sent(10)
+				require.Len(t, sent, 1)
+				assert.Contains(t, sent[0], "错过了")
+			} else {
+				assert.Empty(t, telegram.sent(10))
+			}
+
+			schedules, err := b.storage.ListSchedules(1)
+			require.NoError(t, err)
+			require.Len(t, schedules, 1)
+			if tt.late < 0 {
+				assert.True(t, schedules[0].NextRun.Equal(nextRun), "未到期的计划保持原来的执行时间")
+				return
+			}
+			assert.True(t, schedules[0].NextRun.After(time.Now()), "错过的多次执行只触发一次，下次执行时间从现在算起")
+			assert.Equal(t, 9, schedules[0].NextRun.In(b.userLocation(1)).Hour())
+
+			// 推进后的计划不会在下一次检查中重复触发
+			b.checkSchedules()
+			again, err := b.scheduler.List(1)
+			require.NoError(t, err)
+			assert.Len(t, again, len(jobs))
+		})
+	}
+}
+
+func TestAdvanceScheduleInvalidSpec(t *testing.T) {
+	b, _ := newTestBot(t)
+	schedule := addSchedule(t, b, scheduleStart, time.Now().Add(-time.Minute))
+	schedule.Spec = "not a cron"
+
+	assert.False(t, b.advanceSchedule(schedule))
+	schedules, err := b.storage.ListSchedules(1)
+	require.NoError(t, err)
+	require.Len(t, schedules, 1)
+	assert.WithinDuration(t, time.Now().Add(-time.Minute), schedules[0].NextRun, time.Second)
+}
+
+func TestRescheduleUserOnTimezoneChange(t *testing.T) {
+	b, _ := newTestBot(t)
+	addSchedule(t, b, scheduleStart, time.Now().Add(time.Hour))
+	require.True(t, b.advanceSchedule(addSchedule(t, b, scheduleStop, time.Time{})))
+
+	reply := b.timezoneCommand(commandMessage("/timezone America/New_York"))
+	require.Contains(t, reply, "时区已设置为 America/New_York")
+
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	cron, err := scheduler.ParseCron("0 9 * * *")
+	require.NoError(t, err)
+	want := cron.Next(time.Now().In(newYork))
+	schedules, err := b.storage.ListSchedules(1)
+	require.NoError(t, err)
+	require.Len(t, schedules, 2)
+	for _, schedule := range schedules {
+		assert.True(t, schedule.NextRun.Equal(want), "计划 #%d 按新时区的9点重新计算：%v", schedule.ID, schedule.NextRun)
+	}
+}
+
+func TestScheduleAcrossDST(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	cron, err := scheduler.ParseCron("0 9 * * *")
+	require.NoError(t, err)
+
+	// 2026-03-08 凌晨纽约进入夏令时，9点对应的UTC时间提前一小时
+	before := cron.Next(time.Date(2026, 3, 7, 8, 0, 0, 0, newYork))
+	after := cron.Next(before)
+	assert.Equal(t, time.Date(2026, 3, 7, 14, 0, 0, 0, time.UTC), before.UTC())
+	assert.Equal(t, time.Date(2026, 3, 8, 13, 0, 0, 0, time.UTC), after.UTC())
+	assert.Equal(t, 23*time.Hour, after.Sub(before))
+}
@@ -0,0 +1,195 @@
+package bot
+
+import (
+	"autodl_bot/client"
+	"autodl_bot/models"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	snipeInterval       = 30 * time.Second
+	defaultSnipeTimeout = 6 * time.Hour
+)
+
+// snipeCommand 处理 /snipe <uuid> [--gpus N] [--timeout 6h]，不带参数时列出当前任务
+func (b *Bot) snipeCommand(msg *tgbotapi.Message) string {
+	userID := int(msg.From.ID)
+	if strings.TrimSpace(msg.CommandArguments()) == "" {
+		return b.listSnipes(userID)
+	}
+
+	args, flags, err := parseFlags(msg.CommandArguments(), "gpus", "timeout")
+	if err != nil {
+		return err.Error()
+	}
+	if len(args) != 1 {
+		return "请在命令后附带实例UUID，例如：/snipe xx-yy --gpus 2 --timeout 6h"
+	}
+	if _, err := b.autodlClient(userID); err != nil {
+		return errorReply(err)
+	}
+
+	snipe := &models.Snipe{
+		TelegramID: userID,
+		ChatID:     msg.Chat.ID,
+		UUID:       args[0],
+		GPUs:       1,
+		Deadline:   time.Now().Add(defaultSnipeTimeout),
+	}
+	if value, ok := flags["gpus"]; ok {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return "--gpus 必须是正整数"
+		}
+		snipe.GPUs = n
+	}
+	if value, ok := flags["timeout"]; ok {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			return "--timeout 格式错误，例如：30m、6h"
+		}
+		snipe.Deadline = time.Now().Add(timeout)
+	}
+
+	id, err := b.storage.AddSnipe(snipe)
+	if err != nil {
+		log.Printf("[ERROR] 保存自动开机任务失败: %v", err)
+		return "保存任务失败，请稍后重试"
+	}
+	return fmt.Sprintf("已创建自动开机任务 #%d：实例 %s 空闲GPU达到%d张时自动开机，截止 %s",
+		id, snipe.UUID, snipe.GPUs, snipe.Deadline.Format("01-02 15:04"))
+}
+
+// unsnipeCommand 处理 /unsnipe <任务ID>
+func (b *Bot) unsnipeCommand(msg *tgbotapi.Message) string {
+	id, err := strconv.ParseInt(strings.TrimSpace(msg.CommandArguments()), 10, 64)
+	if err != nil {
+		return "请在命令后附带任务ID，例如：/unsnipe 3"
+	}
+	n, err := b.storage.DeleteSnipe(int(msg.From.ID), id)
+	if err != nil {
+		log.Printf("[ERROR] 删除自动开机任务失败: %v", err)
+		return "取消任务失败，请稍后重试"
+	}
+	if n == 0 {
+		return "没有找到对应的任务，请使用 /snipe 查看"
+	}
+	return fmt.Sprintf("已取消自动开机任务 #%d", id)
+}
+
+func (b *Bot) listSnipes(userID int) string {
+	snipes, err := b.storage.ListSnipes(userID)
+	if err != nil {
+		log.Printf("[ERROR] 读取自动开机任务失败: %v", err)
+		return "读取任务失败，请稍后重试"
+	}
+	if len(snipes) == 0 {
+		return "当前没有自动开机任务，使用 /snipe xx-yy --gpus 2 --timeout 6h 创建"
+	}
+	reply := "自动开机任务：\n"
+	for _, snipe := range snipes {
+		reply += fmt.Sprintf("#%d %s 空闲≥%d 截止 %s\n",
+			snipe.ID, snipe.UUID, snipe.GPUs, snipe.Deadline.Format("01-02 15:04"))
+	}
+	return reply
+}
+
+// runSniper 定期检查自动开机任务，直到stop关闭
+func (b *Bot) runSniper(stop <-chan struct{}) {
+	ticker := time.NewTicker(snipeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.checkSnipes()
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (b *Bot) checkSnipes() {
+	snipes, err := b.storage.ListSnipes(0)
+	if err != nil {
+		log.Printf("[ERROR] 读取自动开机任务失败: %v", err)
+		return
+	}
+
+	byUser := make(map[int][]*models.Snipe)
+	for _, snipe := range snipes {
+		if time.Now().After(snipe.Deadline) {
+			b.finishSnipe(snipe, fmt.Sprintf("自动开机任务 #%d 已超时：实例 %s 在截止时间前没有%d张空闲GPU",
+				snipe.ID, snipe.UUID, snipe.GPUs))
+			continue
+		}
+		byUser[snipe.TelegramID] = append(byUser[snipe.TelegramID], snipe)
+	}
+
+	for userID, userSnipes := range byUser {
+		autodl, err := b.backgroundClient(userID)
+		if err != nil {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+		instances, err := autodl.GetInstancesContext(ctx)
+		cancel()
+		if err != nil {
+			log.Printf("[ERROR] 用户%d的自动开机任务查询实例失败: %v", userID, err)
+			continue
+		}
+
+		for _, snipe := range userSnipes {
+			b.trySnipe(autodl, snipe, instances)
+		}
+	}
+}
+
+// trySnipe 在空闲GPU足够时开机，开机失败且不是GPU被抢占时结束任务
+func (b *Bot) trySnipe(autodl *client.AutoDLClient, snipe *models.Snipe, instances []models.Instance) {
+	instance := findInstance(instances, snipe.UUID)
+	if instance == nil {
+		b.finishSnipe(snipe, fmt.Sprintf("自动开机任务 #%d 已结束：实例 %s 不存在", snipe.ID, snipe.UUID))
+		return
+	}
+	if instance.Status == models.StatusRunning || instance.Status == models.StatusStarting {
+		b.finishSnipe(snipe, fmt.Sprintf("自动开机任务 #%d 已结束：实例 %s 已经开机", snipe.ID, snipe.UUID))
+		return
+	}
+	if instance.GpuIdleNum < snipe.GPUs {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+	if reason := b.checkStartBudget(ctx, snipe.TelegramID, autodl, snipe.UUID, false, budgetStartEstimate); reason != "" {
+		b.finishSnipe(snipe, fmt.Sprintf("自动开机任务 #%d 已结束：%s", snipe.ID, reason))
+		return
+	}
+	err := autodl.PowerOnContext(ctx, snipe.UUID, false)
+	if err != nil {
+		// 检测到空闲GPU后仍可能被他人抢先，GPU被占用或网络抖动时稍后重试，直到任务超时
+		if errors.Is(err, client.ErrNoFreeGPU) || client.IsTemporary(err) {
+			log.Printf("[INFO] 自动开机任务 #%d 开机失败，稍后重试: %v", snipe.ID, err)
+			return
+		}
+		b.finishSnipe(snipe, fmt.Sprintf("自动开机任务 #%d 开机失败：%s", snipe.ID, errorReply(err)))
+		return
+	}
+	b.finishSnipe(snipe, fmt.Sprintf("自动开机任务 #%d：实例 %s 检测到%d张空闲GPU，已开机",
+		snipe.ID, snipe.UUID, instance.GpuIdleNum))
+}
+
+func (b *Bot) finishSnipe(snipe *models.Snipe, text string) {
+	if _, err := b.storage.DeleteSnipe(0, snipe.ID); err != nil {
+		log.Printf("[ERROR] 删除自动开机任务失败: %v", err)
+	}
+	b.sendText(snipe.ChatID, text)
+}
@@ -0,0 +1,118 @@
+package bot
+
+import (
+	"testing"
+	"time"
+
+	"autodl_bot/client"
+	"autodl_bot/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrySnipe(t *testing.T) {
+	const uuid = "xx-yy"
+	tests := []struct {
+		name      string
+		instances []models.Instance
+		powerCode string
+		powerOn   bool
+		finished  string // 为空表示任务保留，等待下次检查
+	}{
+		{name: "空闲GPU不足", instances: []models.Instance{{UUID: uuid, Status: models.StatusShutdown, GpuIdleNum: 1}}},
+		{name: "空闲GPU达到要求", instances: []models.Instance{{UUID: uuid, Status: models.StatusShutdown, GpuIdleNum: 2}},
+			powerOn: true, finished: "检测到2张空闲GPU，已开机"},
+		{name: "空闲GPU超过要求", instances: []models.Instance{{UUID: uuid, Status: models.StatusShutdown, GpuIdleNum: 4}},
+			powerOn: true, finished: "检测到4张空闲GPU，已开机"},
+		{name: "开机时GPU被抢占", instances: []models.Instance{{UUID: uuid, Status: models.StatusShutdown, GpuIdleNum: 2}},
+			powerCode: "GpuNotEnough", powerOn: true},
+		{name: "开机时余额不足", instances: []models.Instance{{UUID: uuid, Status: models.StatusShutdown, GpuIdleNum: 2}},
+			powerCode: "BalanceNotEnough", powerOn: true, finished: "自动开机任务 #1 开机失败：开机失败: 操作失败\n账户余额不足"},
+		{name: "开机时其他错误", instances: []models.Instance{{UUID: uuid, Status: models.StatusShutdown, GpuIdleNum: 2}},
+			powerCode: "SomethingNew", powerOn: true, finished: "开机失败: 操作失败"},
+		{name: "实例不存在", instances: []models.Instance{{UUID: "other", Status: models.StatusShutdown, GpuIdleNum: 8}},
+			finished: "实例 xx-yy 不存在"},
+		{name: "实例已开机", instances: []models.Instance{{UUID: uuid, Status: models.StatusRunning, GpuIdleNum: 8}},
+			finished: "实例 xx-yy 已经开机"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, telegram := newTestBot(t)
+			api := &fakeInstanceAPI{instances: tt.instances, powerCode: tt.powerCode}
+			autodl := newTestClient(t, api)
+			snipe := &models.Snipe{TelegramID: 1, ChatID: 10, UUID: uuid, GPUs: 2, Deadline: time.Now().Add(time.Hour)}
+			id, err := b.storage.AddSnipe(snipe)
+			require.NoError(t, err)
+			snipe.ID = id
+
+			b.trySnipe(autodl, snipe, tt.instances)
+
+			if tt.powerOn {
+				assert.Equal(t, []string{client.PowerOnPath}, api.powerCalls())
+			} else {
+				assert.Empty(t, api.powerCalls())
+			}
+			snipes, err := b.storage.ListSnipes(1)
+			require.NoError(t, err)
+			if tt.finished == "" {
+				assert.Len(t, snipes, 1)
+				assert.Empty(t, telegram.sent(10))
+				return
+			}
+			assert.Empty(t, snipes)
+			sent := telegram.sent(10)
+			require.Len(t, sent, 1)
+			assert.Contains(t, sent[0], tt.finished)
+		})
+	}
+}
+
+func TestCheckSnipesExpires(t *testing.T) {
+	b, telegram := newTestBot(t)
+	_, err := b.storage.AddSnipe(&models.Snipe{
+		TelegramID: 1, ChatID: 10, UUID: "xx-yy", GPUs: 2, Deadline: time.Now().Add(-time.Minute),
+	})
+	require.NoError(t, err)
+	_, err = b.storage.AddSnipe(&models.Snipe{
+		TelegramID: 1, ChatID: 10, UUID: "zz-ww", GPUs: 1, Deadline: time.Now().Add(time.Hour),
+	})
+	require.NoError(t, err)
+
+	// 用户没有配置AutoDL账号，未超时的任务查询不到实例，保留到下次检查
+	b.checkSnipes()
+
+	sent := telegram.sent(10)
+	require.Len(t, sent, 1)
+	assert.Contains(t, sent[0], "已超时：实例 xx-yy 在截止时间前没有2张空闲GPU")
+	snipes, err := b.storage.ListSnipes(1)
+	require.NoError(t, err)
+	require.Len(t, snipes, 1)
+	assert.Equal(t, "zz-ww", snipes[0].UUID)
+}
+
+func TestTrySnipeBudget(t *testing.T) {
+	b, telegram := newTestBot(t)
+	instances := []models.Instance{
+		{UUID: "xx-yy", Status: models.StatusShutdown, GpuIdleNum: 2, ChargeType: models.ChargeTypePayg, PaygPrice: 10000},
+	}
+	api := &fakeInstanceAPI{instances: instances}
+	autodl := newTestClient(t, api)
+	useClient(b, 1, autodl)
+	require.NoError(t, b.storage.SetSetting(1, settingBudgetPrefix+"day", "50"))
+	setDaySpend(t, b, 45)
+	snipe := &models.Snipe{TelegramID: 1, ChatID: 10, UUID: "xx-yy", GPUs: 1, Deadline: time.Now().Add(time.Hour)}
+	id, err := b.storage.AddSnipe(snipe)
+	require.NoError(t, err)
+	snipe.ID = id
+
+	b.trySnipe(autodl, snipe, instances)
+
+	assert.Empty(t, api.powerCalls())
+	sent := telegram.sent(10)
+	require.Len(t, sent, 1)
+	assert.Contains(t, sent[0], "自动开机任务 #1 已结束：预计今日消费将达到 55.00元")
+	snipes, err := b.storage.ListSnipes(1)
+	require.NoError(t, err)
+	assert.Empty(t, snipes)
+}
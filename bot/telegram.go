@@ -3,38 +3,87 @@ package bot
 import (
 	"autodl_bot/client"
 	"autodl_bot/models"
+	"autodl_bot/scheduler"
 	"autodl_bot/storage"
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
+// commandTimeout 是单条命令访问AutoDL的最长时间，避免卡住更新循环
+const commandTimeout = 60 * time.Second
+
+// statusWaitTimeout 是开关机后等待实例进入目标状态的最长时间
+const statusWaitTimeout = 10 * time.Minute
+
 type Bot struct {
 	api         *tgbotapi.BotAPI
-	autodl      *client.AutoDLClient
+	clients     *clientPool
 	userConfig  map[int]*models.AutoDLConfig
 	configMutex sync.RWMutex
 	storage     *storage.UserStorage
+
+	scheduler *scheduler.Scheduler
+	commands  *router
+	handle    commandHandler
+
+	admins       []int64
+	allowedUsers map[int64]bool
+	limiter      *rateLimiter
+
+	autodlBaseURL string
+	dispatcher    *dispatcher
+	callbackKey   []byte
+	pendingLogins *pendingLogins
 }
 
-func NewBot(token string, proxy *http.Client) (*Bot, error) {
+// Options 是Bot的可选配置
+type Options struct {
+	// Admins 是管理员的Telegram ID，会收到命令出错的通知，且不受限流限制
+	Admins []int64
+	// AllowedUsers 是允许使用Bot的Telegram ID，为空时所有人都可以使用
+	AllowedUsers []int64
+
+	// APIEndpoint 是Telegram Bot API地址格式，为空时使用 tgbotapi.APIEndpoint
+	APIEndpoint string
+	// AutoDLBaseURL 是AutoDL接口地址，为空时使用 client.BaseURL
+	AutoDLBaseURL string
+	// DBPath 是数据库文件路径，为空时使用 storage.DBPath
+	DBPath string
+
+	// Workers 是同时处理更新的用户数，QueueSize 是排队更新数的上限，为0时使用默认值
+	Workers   int
+	QueueSize int
+}
+
+func NewBot(token string, proxy *http.Client, opts Options) (*Bot, error) {
 	var api *tgbotapi.BotAPI
 	var err error
 
-	if proxy != nil {
-		api, err = tgbotapi.NewBotAPIWithClient(token, tgbotapi.APIEndpoint, proxy)
-	} else {
-		api, err = tgbotapi.NewBotAPI(token)
+	endpoint := opts.APIEndpoint
+	if endpoint == "" {
+		endpoint = tgbotapi.APIEndpoint
+	}
+	if proxy == nil {
+		proxy = &http.Client{}
 	}
+	api, err = tgbotapi.NewBotAPIWithClient(token, endpoint, proxy)
 	if err != nil {
 		return nil, err
 	}
 
-	userStg, err := storage.NewUserStorage()
+	dbPath := opts.DBPath
+	if dbPath == "" {
+		dbPath = storage.DBPath
+	}
+	userStg, err := storage.OpenUserStorage(dbPath)
 	if err != nil {
 		return nil, err
 	}
@@ -44,61 +93,73 @@ func NewBot(token string, proxy *http.Client) (*Bot, error) {
 		return nil, err
 	}
 
-	commands := []tgbotapi.BotCommand{
-		{
-			Command:     "user",
-			Description: "设置用户名",
-		},
-		{
-			Command:     "password",
-			Description: "设置密码",
-		},
-		{
-			Command:     "gpuvalid",
-			Description: "查看GPU空闲情况",
-		},
-		{
-			Command:     "start",
-			Description: "启动GPU实例",
-		},
-		{
-			Command:     "startcpu",
-			Description: "启动GPU实例(无卡模式)",
-		},
-		{
-			Command:     "stop",
-			Description: "关闭GPU实例",
-		},
-		{
-			Command:     "refresh",
-			Description: "刷新GPU实例释放时长",
-		},
-		{
-			Command:     "getuser",
-			Description: "列出当前用户",
-		},
-		{
-			Command:     "balance",
-			Description: "查看用户余额",
-		},
+	bot := &Bot{
+		api:           api,
+		userConfig:    userConfig,
+		storage:       userStg,
+		admins:        opts.Admins,
+		allowedUsers:  make(map[int64]bool),
+		limiter:       newRateLimiter(commandBurst, commandRefill),
+		autodlBaseURL: opts.AutoDLBaseURL,
+		callbackKey:   newCallbackKey(token),
+		pendingLogins: newPendingLogins(),
+	}
+	bot.dispatcher = newDispatcher(opts.Workers, opts.QueueSize, bot.handleUpdate)
+	for _, id := range opts.AllowedUsers {
+		bot.allowedUsers[id] = true
 	}
+	bot.clients = newClientPool(clientIdleTTL, bot.setupClient)
+	bot.scheduler = scheduler.New(userStg, bot.sendText)
+	bot.scheduler.FormatError = errorReply
+	bot.registerJobs()
+	bot.commands = bot.registerCommands()
+	bot.handle = bot.commandPipeline()
 
 	// 设置命令菜单
-	cmdConfig := tgbotapi.NewSetMyCommands(commands...)
+	cmdConfig := tgbotapi.NewSetMyCommands(bot.commands.botCommands()...)
 	_, err = api.Request(cmdConfig)
 	if err != nil {
 		return nil, fmt.Errorf("设置命令菜单失败: %v", err)
 	}
+	return bot, nil
+}
 
-	return &Bot{
-		api:        api,
-		userConfig: userConfig,
-		storage:    userStg,
-	}, nil
+// setupClient 配置新建的客户端并恢复会话，cfg 是创建客户端时使用的配置
+func (b *Bot) setupClient(userID int, cfg models.AutoDLConfig, autodl *client.AutoDLClient) {
+	if b.autodlBaseURL != "" {
+		autodl.SetBaseURL(b.autodlBaseURL)
+	}
+	b.restoreSession(userID, cfg.Username, autodl)
+}
+
+// restoreSession 为新建的客户端恢复已保存的token，并在重新登录后保存新token。
+// username 必须是创建客户端时的用户名，不能读取当前配置，否则并发的 /user 会把token记到其他账号下
+func (b *Bot) restoreSession(userID int, username string, autodl *client.AutoDLClient) {
+	token, acquiredAt, err := b.storage.LoadToken(userID, username)
+	if err != nil {
+		log.Printf("[ERROR] 读取用户%d的token失败: %v", userID, err)
+	} else if token != "" {
+		autodl.SetToken(token)
+		log.Printf("[INFO] 恢复用户%d于%s获取的token", userID, acquiredAt.Format("2006-01-02 15:04:05"))
+	}
+
+	autodl.SetTokenHook(func(token string, acquiredAt time.Time) {
+		if err := b.storage.SaveToken(userID, username, token, acquiredAt); err != nil {
+			log.Printf("[ERROR] 保存用户%d的token失败: %v", userID, err)
+		}
+	})
+}
+
+// resetSession 在用户修改账号或密码后丢弃旧客户端和已保存的token
+func (b *Bot) resetSession(userID int) {
+	b.clients.invalidate(userID)
+	if err := b.storage.DeleteToken(userID); err != nil {
+		log.Printf("[ERROR] 删除用户%d的token失败: %v", userID, err)
+	}
 }
 func (b *Bot) getUserConfig(userId int) *models.AutoDLConfig {
-	b.configMutex.RLock()
-	defer b.configMutex.RUnlock()
+	b.configMutex.Lock()
+	defer b.configMutex.Unlock()
 
 	cfg, exist := b.userConfig[userId]
 	if !exist {
@@ -107,6 +168,20 @@ func (b *Bot) getUserConfig(userId int) *models.AutoDLConfig {
 	}
 	return cfg
 }
+
+// configuredUsers 返回已设置AutoDL用户名和密码的用户
+func (b *Bot) configuredUsers() []int {
+	b.configMutex.RLock()
+	defer b.configMutex.RUnlock()
+	var users []int
+	for id, cfg := range b.userConfig {
+		if cfg.Username != "" && cfg.Password != "" {
+			users = append(users, id)
+		}
+	}
+	return users
+}
+
 func (b *Bot) SetUserConfig(userId int, cfg *models.AutoDLConfig) {
 	b.configMutex.Lock()
 	defer b.configMutex.Unlock()
@@ -134,179 +209,158 @@ func (b *Bot) CurrentUser(userId int) string {
 	}
 }
 
-func (b *Bot) initAutoDLClient(userID int) error {
-	cfg := b.getUserConfig(userID)
-	if cfg.Username == "" || cfg.Password == "" {
-		return fmt.Errorf("请先设置AutoDL用户名和密码")
+// configSnapshot 返回用户配置的副本，未设置时返回零值
+func (b *Bot) configSnapshot(userID int) models.AutoDLConfig {
+	b.configMutex.RLock()
+	defer b.configMutex.RUnlock()
+	if cfg, exist := b.userConfig[userID]; exist {
+		return *cfg
 	}
+	return models.AutoDLConfig{}
+}
 
-	b.autodl = client.NewAutoDLClient(cfg.Username, cfg.Password)
-	return nil
+// autodlClient 返回当前用户自己的AutoDL客户端
+func (b *Bot) autodlClient(userID int) (*client.AutoDLClient, error) {
+	return b.clients.get(userID, b.configSnapshot(userID))
 }
 
-func (b *Bot) Start() error {
-	updateConfig := tgbotapi.NewUpdate(0)
-	updateConfig.Timeout = 30
+// backgroundClient 返回后台轮询和定时任务使用的客户端，不计为用户使用，不影响空闲回收
+func (b *Bot) backgroundClient(userID int) (*client.AutoDLClient, error) {
+	return b.clients.getBackground(userID, b.configSnapshot(userID))
+}
 
-	updatesCh := b.api.GetUpdatesChan(updateConfig)
+// sendText 向指定会话发送一条文本消息
+func (b *Bot) sendText(chatID int64, text string) {
+	_, err := b.api.Send(tgbotapi.NewMessage(chatID, text))
+	if err != nil {
+		log.Printf("error sending message: %v", err)
+	}
+}
 
-	for update := range updatesCh {
-		if update.Message == nil {
-			continue
-		}
+// reportStatus 等待实例进入目标状态，并把最终结果发送给用户
+func (b *Bot) reportStatus(chatID int64, autodl *client.AutoDLClient, uuid, target string, since time.Time) {
+	_, err := autodl.WaitForStatus(uuid, target, statusWaitTimeout)
+	if err != nil {
+		b.sendText(chatID, errorReply(err))
+		return
+	}
+	b.sendText(chatID, fmt.Sprintf("实例 %s 已%s，耗时%s", uuid, statusText(target), formatElapsed(time.Since(since))))
+}
 
-		userID := update.Message.From.ID
-		cfg := b.getUserConfig(int(userID))
+func statusText(status string) string {
+	switch status {
+	case models.StatusRunning:
+		return "运行"
+	case models.StatusShutdown:
+		return "关机"
+	case models.StatusStarting:
+		return "开机中"
+	case models.StatusShuttingDown:
+		return "关机中"
+	}
+	return status
+}
 
-		// process command
-		if update.Message.IsCommand() {
-			b.Command(update.Message, cfg)
-		} else {
-			// not supported command
-			msg := tgbotapi.NewMessage(update.Message.Chat.ID, "未知命令，请使用 /help 查看支持的命令")
-			b.api.Send(msg)
-		}
+func formatElapsed(d time.Duration) string {
+	seconds := int(d.Round(time.Second) / time.Second)
+	if seconds < 60 {
+		return fmt.Sprintf("%d秒", seconds)
 	}
-	return nil
+	return fmt.Sprintf("%d分%d秒", seconds/60, seconds%60)
 }
-func (b *Bot) Command(msg *tgbotapi.Message, cfg *models.AutoDLConfig) {
-	var reply string
-
-	switch msg.Command() {
-	case "help":
-		reply = `支持的命令：
-/user - 设置AutoDL用户名（手机号）
-/password - 设置AutoDL密码
-/gpuvalid - 查看所有GPU实例空闲情况
-/start - 打开实例
-/startcpu - 打开实例(无卡模式)
-/stop - 关闭实例
-/refresh - 刷新实例释放时长
-/getuser - 列出当前已设置的用户
-/balance - 查看用户余额`
-
-	case "user":
-		if msg.CommandArguments() == "" {
-			reply = "请在命令后附带用户名，例如：/user 18900000000"
-		} else {
-			cfg.Username = msg.CommandArguments()
-			b.SetUserConfig(int(msg.From.ID), cfg)
-			reply = "用户名设置成功"
-		}
 
-	case "password":
-		if msg.CommandArguments() == "" {
-			reply = "请在命令后附带密码，例如：/password 123456"
-		} else {
-			rawPassword := msg.CommandArguments()
-			cfg.Password = client.HashPassword(rawPassword)
-			b.SetUserConfig(int(msg.From.ID), cfg)
-			reply = "密码设置成功"
+// errorReply 把AutoDL错误转换成回复文本，并针对已知错误类别附带处理建议
+func errorReply(err error) string {
+	reply := err.Error()
+	switch {
+	case errors.Is(err, client.ErrCaptchaRequired), errors.Is(err, client.ErrSMSCodeRequired):
+		reply += "\nAutoDL要求验证登录，请使用 /login 按提示输入验证码"
+	case errors.Is(err, client.ErrAuthorizeFailed):
+		reply += "\n请使用 /user 和 /password 重新设置AutoDL账号"
+	case errors.Is(err, client.ErrInvalidCredentials):
+		reply += "\n请检查手机号是否正确，并使用 /password 重新设置密码"
+	case errors.Is(err, client.ErrInsufficientBalance):
+		reply += "\n账户余额不足，请先在AutoDL控制台充值"
+	case errors.Is(err, client.ErrNoFreeGPU):
+		reply += "\n当前主机没有空闲GPU，可使用 /snipe 在GPU空闲时自动开机，或使用 /startcpu 无卡模式开机"
+	case errors.Is(err, client.ErrInstanceNotFound):
+		reply += "\n请使用 /gpuvalid 确认实例UUID"
+	case errors.Is(err, context.DeadlineExceeded):
+		reply = "请求AutoDL超时，请稍后重试"
+	}
+	return reply
+}
 
-			b.initAutoDLClient(int(msg.From.ID))
+// parseInstanceFilter 解析 key=value 形式的实例过滤条件，多个值用逗号分隔
+func parseInstanceFilter(args string) (client.InstanceFilter, error) {
+	var filter client.InstanceFilter
+	for _, field := range strings.Fields(args) {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok || value == "" {
+			return filter, fmt.Errorf("无法识别的过滤条件：%s，格式为 key=value", field)
 		}
-
-	case "gpuvalid":
-		if b.autodl == nil {
-			err := b.initAutoDLClient(int(msg.From.ID))
-			if err != nil {
-				reply = err.Error()
-				break
-			}
+		switch key {
+		case "status":
+			filter.Status = append(filter.Status, strings.Split(value, ",")...)
+		case "charge":
+			filter.ChargeType = append(filter.ChargeType, strings.Split(value, ",")...)
+		case "from":
+			filter.DateFrom = value
+		case "to":
+			filter.DateTo = value
+		default:
+			return filter, fmt.Errorf("不支持的过滤条件：%s，可用：status, charge, from, to", key)
 		}
+	}
+	return filter, nil
+}
 
-		gpuStatus, err := b.autodl.GetGPUStatus()
-		if err != nil {
-			reply = fmt.Sprintf("获取GPU状态失败：%v", err)
-		} else {
-			reply = gpuStatus
-		}
+func (b *Bot) Start() error {
+	updateConfig := tgbotapi.NewUpdate(0)
+	updateConfig.Timeout = 30
 
-	case "start", "startcpu":
-		if msg.CommandArguments() == "" {
-			reply = "请在命令后附带实例UUID，例如：/start xx-yy"
-		} else if b.autodl == nil {
-			err := b.initAutoDLClient(int(msg.From.ID))
-			if err != nil {
-				reply = err.Error()
-				break
-			}
-		} else {
-			useCPU := msg.Command() == "startcpu"
-			uuid := msg.CommandArguments()
-			err := b.autodl.PowerOn(uuid, useCPU)
-			if err != nil {
-				reply = err.Error()
-			} else {
-				reply = fmt.Sprintf("实例 %s 开机成功", uuid)
-			}
-		}
-	case "stop":
-		if msg.CommandArguments() == "" {
-			reply = "请在命令后附带实例UUID，例如：/stop xx-yy"
-		} else if b.autodl == nil {
-			err := b.initAutoDLClient(int(msg.From.ID))
-			if err != nil {
-				reply = err.Error()
-				break
-			}
-		} else {
-			uuid := msg.CommandArguments()
-			err := b.autodl.PowerOff(uuid)
-			if err != nil {
-				reply = err.Error()
-			} else {
-				reply = fmt.Sprintf("实例 %s 关机成功", uuid)
-			}
-		}
-	case "refresh":
-		if msg.CommandArguments() == "" {
-			reply = "请在命令后附带实例UUID，例如：/refresh xx-yy"
-		} else if b.autodl == nil {
-			err := b.initAutoDLClient(int(msg.From.ID))
-			if err != nil {
-				reply = err.Error()
-				break
-			}
-		} else {
-			uuid := msg.CommandArguments()
-			err := b.autodl.PowerOn(uuid, true)
-			if err != nil {
-				reply = err.Error()
-			} else {
-				reply = fmt.Sprintf("实例 %s 无卡模式开机成功，10秒后关机", uuid)
-				go func() {
-					// 10秒后关机
-					<-time.After(10 * time.Second)
-					err := b.autodl.PowerOff(uuid)
-					if err != nil {
-						log.Printf("刷新实例 %s 释放时长失败: %v", uuid, err)
-					}
-				}()
-			}
-		}
-	case "balance":
-		if b.autodl == nil {
-			err := b.initAutoDLClient(int(msg.From.ID))
-			if err != nil {
-				reply = err.Error()
-				break
-			}
+	updatesCh := b.api.GetUpdatesChan(updateConfig)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go b.clients.runEvictor(clientEvictInterval, stop)
+	go b.limiter.runEvictor(limiterEvictInterval, stop)
+	go b.runWatcher(stop)
+	go b.runSniper(stop)
+	go b.runAutoRefresher(stop)
+	go b.runReleaseWarner(stop)
+	go b.scheduler.Run(stop)
+	go b.runSchedules(stop)
+	go b.runRunningMonitor(stop)
+	go b.runBalancePoller(stop)
+
+	for update := range updatesCh {
+		userID, ok := updateUser(update)
+		if !ok {
+			continue
 		}
-		balance, err := b.autodl.GetBalance()
-		if err != nil {
-			reply = err.Error()
-		} else {
-			reply = fmt.Sprintf("当前余额: %.2f元", balance)
+		if !b.dispatcher.submit(userID, update) {
+			b.rejectBusy(update)
 		}
+	}
+	b.dispatcher.wait()
+	return nil
+}
 
-	case "getuser":
-		reply = b.CurrentUser(int(msg.From.ID))
+// Stop 停止接收更新，Start 会在已接收的更新处理完成后返回
+func (b *Bot) Stop() {
+	b.api.StopReceivingUpdates()
+}
 
-	default:
-		reply = "未知命令，请使用 /help 查看支持的命令"
-	}
+func (b *Bot) Command(msg *tgbotapi.Message) {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
 
+	reply := b.handle(ctx, msg)
+	// 回复为空表示处理函数已自行发送消息
+	if reply == "" {
+		return
+	}
 	replyMsg := tgbotapi.NewMessage(msg.Chat.ID, reply)
 
 	_, err := b.api.Send(replyMsg)
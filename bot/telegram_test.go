@@ -0,0 +1,51 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"autodl_bot/client"
+	"autodl_bot/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorReplyFromServerCode(t *testing.T) {
+	tests := []struct {
+		code     string
+		sentinel error
+		hint     string
+	}{
+		{code: "BalanceNotEnough", sentinel: client.ErrInsufficientBalance, hint: "请先在AutoDL控制台充值"},
+		{code: "GpuNotEnough", sentinel: client.ErrNoFreeGPU, hint: "可使用 /snipe 在GPU空闲时自动开机"},
+		{code: "PasswordIncorrect", sentinel: client.ErrInvalidCredentials, hint: "使用 /password 重新设置密码"},
+		{code: "UserNotExist", sentinel: client.ErrInvalidCredentials, hint: "使用 /password 重新设置密码"},
+		{code: "InstanceNotFound", sentinel: client.ErrInstanceNotFound, hint: "请使用 /gpuvalid 确认实例UUID"},
+		{code: client.CaptchaRequiredCode, sentinel: client.ErrCaptchaRequired, hint: "请使用 /login 按提示输入验证码"},
+		{code: client.SMSCodeRequiredCode, sentinel: client.ErrSMSCodeRequired, hint: "请使用 /login 按提示输入验证码"},
+		{code: "SomethingNew"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			autodl := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				json.NewEncoder(w).Encode(models.PowerResponse{Code: tt.code, Msg: "接口提示"})
+			}))
+			err := autodl.PowerOnContext(context.Background(), "xx-yy", false)
+			require.Error(t, err)
+			if tt.sentinel != nil {
+				assert.ErrorIs(t, err, tt.sentinel)
+			}
+
+			reply := errorReply(err)
+			assert.Contains(t, reply, "开机失败: 接口提示")
+			if tt.hint == "" {
+				assert.Equal(t, "开机失败: 接口提示", reply, "未收录的code不附带建议")
+			} else {
+				assert.Contains(t, reply, tt.hint)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package bot
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	settingTimezone = "timezone"
+	// defaultTimezone 与AutoDL控制台显示的时间一致
+	defaultTimezone = "Asia/Shanghai"
+)
+
+// userLocation 返回用户设置的时区，未设置或无效时使用默认时区
+func (b *Bot) userLocation(userID int) *time.Location {
+	name, exist, err := b.storage.GetSetting(userID, settingTimezone)
+	if err != nil {
+		log.Printf("[ERROR] 读取时区设置失败: %v", err)
+	}
+	if !exist || name == "" {
+		name = defaultTimezone
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		log.Printf("[ERROR] 加载时区 %s 失败: %v", name, err)
+		return time.FixedZone(defaultTimezone, 8*60*60)
+	}
+	return loc
+}
+
+// timezoneCommand 处理 /timezone [时区]，不带参数时显示当前时区
+func (b *Bot) timezoneCommand(msg *tgbotapi.Message) string {
+	userID := int(msg.From.ID)
+	name := strings.TrimSpace(msg.CommandArguments())
+	if name == "" {
+		loc := b.userLocation(userID)
+		return fmt.Sprintf("当前时区：%s（%s），使用 /timezone Europe/London 修改",
+			loc, time.Now().In(loc).Format("2006-01-02 15:04"))
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil || name == "Local" {
+		return "无效的时区，请使用IANA时区名称，例如：Asia/Shanghai、Europe/London、UTC"
+	}
+	if err := b.storage.SetSetting(userID, settingTimezone, loc.String()); err != nil {
+		log.Printf("[ERROR] 保存时区设置失败: %v", err)
+		return "保存设置失败，请稍后重试"
+	}
+	b.rescheduleUser(userID)
+	return fmt.Sprintf("时区已设置为 %s，当前时间 %s", loc, time.Now().In(loc).Format("2006-01-02 15:04"))
+}
@@ -0,0 +1,181 @@
+package bot
+
+import (
+	"autodl_bot/models"
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	watchInterval = time.Minute
+	// watchResetPolls 是空闲数连续低于阈值多少次后才允许再次提醒，避免数值抖动反复提醒
+	watchResetPolls = 3
+)
+
+// watchCommand 处理 /watch <uuid|别名> [最少空闲GPU数]
+func (b *Bot) watchCommand(msg *tgbotapi.Message) string {
+	args := strings.Fields(msg.CommandArguments())
+	if len(args) == 0 || len(args) > 2 {
+		return "请在命令后附带实例UUID或机器别名，以及可选的最少空闲GPU数，例如：/watch xx-yy 2"
+	}
+	userID := int(msg.From.ID)
+	if _, err := b.autodlClient(userID); err != nil {
+		return errorReply(err)
+	}
+
+	minIdle := 1
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			return "最少空闲GPU数必须是正整数"
+		}
+		minIdle = n
+	}
+
+	watch := &models.Watch{
+		TelegramID: userID,
+		ChatID:     msg.Chat.ID,
+		Target:     args[0],
+		MinIdle:    minIdle,
+	}
+	id, err := b.storage.AddWatch(watch)
+	if err != nil {
+		log.Printf("[ERROR] 保存GPU空闲提醒失败: %v", err)
+		return "保存提醒失败，请稍后重试"
+	}
+	return fmt.Sprintf("已订阅 #%d：%s 空闲GPU达到%d张时提醒", id, watch.Target, watch.MinIdle)
+}
+
+// unwatchCommand 处理 /unwatch <订阅ID|uuid|别名>
+func (b *Bot) unwatchCommand(msg *tgbotapi.Message) string {
+	target := strings.TrimSpace(msg.CommandArguments())
+	if target == "" {
+		return "请在命令后附带订阅ID或实例UUID，例如：/unwatch 3"
+	}
+	n, err := b.storage.DeleteWatch(int(msg.From.ID), target)
+	if err != nil {
+		log.Printf("[ERROR] 删除GPU空闲提醒失败: %v", err)
+		return "删除提醒失败，请稍后重试"
+	}
+	if n == 0 {
+		return "没有找到对应的订阅，请使用 /watches 查看"
+	}
+	return fmt.Sprintf("已取消%d个订阅", n)
+}
+
+// watchesCommand 处理 /watches，列出用户的订阅
+func (b *Bot) watchesCommand(msg *tgbotapi.Message) string {
+	watches, err := b.storage.ListWatches(int(msg.From.ID))
+	if err != nil {
+		log.Printf("[ERROR] 读取GPU空闲提醒失败: %v", err)
+		return "读取订阅失败，请稍后重试"
+	}
+	if len(watches) == 0 {
+		return "当前没有订阅，使用 /watch 添加"
+	}
+	reply := "当前订阅：\n"
+	for _, w := range watches {
+		reply += fmt.Sprintf("#%d %s 空闲≥%d\n", w.ID, w.Target, w.MinIdle)
+	}
+	return reply
+}
+
+// runWatcher 定期检查所有订阅，直到stop关闭
+func (b *Bot) runWatcher(stop <-chan struct{}) {
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+
+	belowCounts := make(map[int64]int)
+	for {
+		select {
+		case <-ticker.C:
+			b.checkWatches(belowCounts)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// checkWatches 按账号查询一次实例，并对达到阈值的订阅发送提醒
+func (b *Bot) checkWatches(belowCounts map[int64]int) {
+	watches, err := b.storage.ListWatches(0)
+	if err != nil {
+		log.Printf("[ERROR] 读取GPU空闲提醒失败: %v", err)
+		return
+	}
+
+	byUser := make(map[int][]*models.Watch)
+	for _, w := range watches {
+		byUser[w.TelegramID] = append(byUser[w.TelegramID], w)
+	}
+
+	for userID, userWatches := range byUser {
+		autodl, err := b.backgroundClient(userID)
+		if err != nil {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+		instances, err := autodl.GetInstancesContext(ctx)
+		cancel()
+		if err != nil {
+			log.Printf("[ERROR] 用户%d的GPU空闲提醒查询实例失败: %v", userID, err)
+			continue
+		}
+
+		for _, w := range userWatches {
+			b.evaluateWatch(w, instances, belowCounts)
+		}
+	}
+}
+
+func (b *Bot) evaluateWatch(w *models.Watch, instances []models.Instance, belowCounts map[int64]int) {
+	instance := bestWatchMatch(w.Target, instances)
+	if instance == nil {
+		return
+	}
+
+	if instance.GpuIdleNum >= w.MinIdle {
+		belowCounts[w.ID] = 0
+		if w.Notified {
+			return
+		}
+		b.sendText(w.ChatID, fmt.Sprintf("GPU空闲提醒 #%d：%s-%s（%s）当前空闲GPU %d/%d",
+			w.ID, instance.RegionName, instance.MachineAlias, instance.UUID, instance.GpuIdleNum, instance.GpuAllNum))
+		if err := b.storage.SetWatchNotified(w.ID, true); err != nil {
+			log.Printf("[ERROR] 更新GPU空闲提醒状态失败: %v", err)
+		}
+		return
+	}
+
+	if !w.Notified {
+		return
+	}
+	belowCounts[w.ID]++
+	if belowCounts[w.ID] >= watchResetPolls {
+		delete(belowCounts, w.ID)
+		if err := b.storage.SetWatchNotified(w.ID, false); err != nil {
+			log.Printf("[ERROR] 更新GPU空闲提醒状态失败: %v", err)
+		}
+	}
+}
+
+// bestWatchMatch 按UUID或机器别名查找实例，别名对应多个实例时取空闲GPU最多的
+func bestWatchMatch(target string, instances []models.Instance) *models.Instance {
+	var best *models.Instance
+	for i := range instances {
+		instance := &instances[i]
+		if instance.UUID != target && !strings.EqualFold(instance.MachineAlias, target) {
+			continue
+		}
+		if best == nil || instance.GpuIdleNum > best.GpuIdleNum {
+			best = instance
+		}
+	}
+	return best
+}
@@ -0,0 +1,69 @@
+package bot
+
+import (
+	"testing"
+
+	"autodl_bot/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvaluateWatchDebounce(t *testing.T) {
+	b, telegram := newTestBot(t)
+	id, err := b.storage.AddWatch(&models.Watch{TelegramID: 1, ChatID: 1, Target: "uuid-1", MinIdle: 2})
+	require.NoError(t, err)
+
+	belowCounts := make(map[int64]int)
+	poll := func(idle int) {
+		watches, err := b.storage.ListWatches(1)
+		require.NoError(t, err)
+		require.Len(t, watches, 1)
+		instances := []models.Instance{{UUID: "uuid-1", GpuIdleNum: idle, GpuAllNum: 8}}
+		b.evaluateWatch(watches[0], instances, belowCounts)
+	}
+	notified := func() bool {
+		watches, err := b.storage.ListWatches(1)
+		require.NoError(t, err)
+		return watches[0].Notified
+	}
+
+	poll(1)
+	assert.Empty(t, telegram.sent(1), "未达到阈值不提醒")
+
+	poll(2)
+	assert.Len(t, telegram.sent(1), 1)
+	assert.Contains(t, telegram.sent(1)[0], "GPU空闲提醒 #")
+	assert.True(t, notified())
+
+	poll(3)
+	assert.Len(t, telegram.sent(1), 1, "已提醒后不重复提醒")
+
+	// 短暂回落后又达到阈值，不算回落
+	for i := 0; i < watchResetPolls-1; i++ {
+		poll(0)
+	}
+	assert.True(t, notified())
+	poll(2)
+	assert.Zero(t, belowCounts[id])
+	assert.Len(t, telegram.sent(1), 1)
+
+	// 连续回落 watchResetPolls 次后允许再次提醒
+	for i := 0; i < watchResetPolls; i++ {
+		poll(0)
+	}
+	assert.False(t, notified())
+	poll(2)
+	assert.Len(t, telegram.sent(1), 2)
+}
+
+func TestBestWatchMatch(t *testing.T) {
+	instances := []models.Instance{
+		{UUID: "a", MachineAlias: "西北B区-1", GpuIdleNum: 1},
+		{UUID: "b", MachineAlias: "西北B区-1", GpuIdleNum: 3},
+		{UUID: "c", MachineAlias: "北京A区-2", GpuIdleNum: 5},
+	}
+	assert.Equal(t, "b", bestWatchMatch("西北B区-1", instances).UUID, "别名对应多个实例时取空闲GPU最多的")
+	assert.Equal(t, "a", bestWatchMatch("a", instances).UUID)
+	assert.Nil(t, bestWatchMatch("missing", instances))
+}
@@ -2,11 +2,16 @@ package client
 
 import (
 	"autodl_bot/models"
+	"context"
 	"crypto/sha1"
+	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -21,19 +26,47 @@ const (
 	PowerOnPath  = "/instance/power_on"
 	PowerOffPath = "/instance/power_off"
 	BalancePath  = "/wallet"
+	CaptchaPath  = "/login/picture_code"
+	SendSMSPath  = "/login/send_v_code"
+)
+
+// DefaultTimeout 是单个HTTP请求的超时时间
+const DefaultTimeout = 30 * time.Second
+
+// DefaultStatusPollInterval 是等待实例状态变化时的轮询间隔
+const DefaultStatusPollInterval = 3 * time.Second
+
+// ReleaseAfter 是实例关机后被AutoDL释放的时长
+const ReleaseAfter = 15 * 24 * time.Hour
+
+// InstancePageSize 是分页查询实例时每页的数量
+const InstancePageSize = 20
+
+// AuthorizeFailedCode 是token失效时接口返回的code
+const AuthorizeFailedCode = "AuthorizeFailed"
+
+// 登录需要验证时接口返回的code，附带验证码重新登录即可
+const (
+	CaptchaRequiredCode = "PictureCodeRequired"
+	SMSCodeRequiredCode = "VCodeRequired"
 )
 
 type AutoDLClient struct {
 	client     *resty.Client
 	token      string
 	tokenMutex sync.RWMutex
+	loginMutex sync.Mutex
 	username   string
 	password   string
+
+	statusPollInterval time.Duration
+	tokenHook          func(token string, acquiredAt time.Time)
 }
 
 func NewAutoDLClient(username, password string) *AutoDLClient {
 	client := resty.New()
 	client.SetBaseURL(BaseURL)
+	client.SetTimeout(DefaultTimeout)
 	client.SetHeaders(map[string]string{
 		"accept":             "*/*",
 		"accept-language":    "zh-CN,zh;q=0.9",
@@ -45,12 +78,18 @@ func NewAutoDLClient(username, password string) *AutoDLClient {
 	})
 
 	return &AutoDLClient{
-		client:   client,
-		username: username,
-		password: password,
+		client:             client,
+		username:           username,
+		password:           password,
+		statusPollInterval: DefaultStatusPollInterval,
 	}
 }
 
+// SetBaseURL 修改AutoDL接口地址，用于测试或通过代理访问
+func (c *AutoDLClient) SetBaseURL(baseURL string) {
+	c.client.SetBaseURL(baseURL)
+}
+
 func (c *AutoDLClient) getToken() string {
 	c.tokenMutex.RLock()
 	defer c.tokenMutex.RUnlock()
@@ -63,25 +102,57 @@ func (c *AutoDLClient) setToken(token string) {
 }
 
 func (c *AutoDLClient) Login() error {
+	return c.LoginContext(context.Background())
+}
+
+// SetToken 恢复之前保存的token，token被接口拒绝时会自动重新登录
+func (c *AutoDLClient) SetToken(token string) {
+	c.setToken(token)
+}
+
+// SetTokenHook 设置登录获得新token后的回调，用于持久化token
+func (c *AutoDLClient) SetTokenHook(hook func(token string, acquiredAt time.Time)) {
+	c.tokenHook = hook
+}
+
+func (c *AutoDLClient) LoginContext(ctx context.Context) error {
+	return c.login(ctx, LoginVerification{})
+}
+
+// LoginVerification 是登录时附带的图形验证码或短信验证码
+type LoginVerification struct {
+	PictureID string // 图形验证码ID，短信验证码登录时为空
+	VCode     string
+}
+
+// LoginWithVerificationContext 附带验证码登录，用于登录返回 ErrCaptchaRequired 或 ErrSMSCodeRequired 之后。
+// 与token失效时的自动重新登录互斥
+func (c *AutoDLClient) LoginWithVerificationContext(ctx context.Context, verification LoginVerification) error {
+	c.loginMutex.Lock()
+	defer c.loginMutex.Unlock()
+	return c.login(ctx, verification)
+}
+
+func (c *AutoDLClient) login(ctx context.Context, verification LoginVerification) error {
 	loginReqest := models.LoginRequest{
 		Phone:     c.username,
 		Password:  c.password,
-		VCode:     "",
+		VCode:     verification.VCode,
 		PhoneArea: "+86",
 		PictureID: nil,
 	}
+	if verification.PictureID != "" {
+		loginReqest.PictureID = verification.PictureID
+	}
 	var loginResponse models.LoginResponse
-	_, err := c.client.R().
-		SetBody(loginReqest).
-		SetResult(&loginResponse).
-		Post(LoginPATH)
+	err := c.doRequest(ctx, http.MethodPost, LoginPATH, loginReqest, &loginResponse)
 	if err != nil {
 		log.Printf("[ERROR] 登录请求失败: %v", err)
 		return err
 	}
 	if loginResponse.Code != "Success" {
-		log.Printf("[ERROR] 登录失败: %v", err)
-		return errors.New(loginResponse.Msg)
+		log.Printf("[ERROR] 登录失败: %s %s", loginResponse.Code, loginResponse.Msg)
+		return newAPIError("登录", loginResponse.Code, loginResponse.Msg)
 	}
 
 	// get token
@@ -89,100 +160,416 @@ func (c *AutoDLClient) Login() error {
 		Ticket: loginResponse.Data.Ticket,
 	}
 	var passportResponse models.PassportResponse
-	_, err = c.client.R().
-		SetBody(passportRequest).
-		SetResult(&passportResponse).
-		Post(PassportPath)
+	err = c.doRequest(ctx, http.MethodPost, PassportPath, passportRequest, &passportResponse)
 	if err != nil {
 		log.Printf("[ERROR] 获取 token 请求失败: %v", err)
 		return err
 	}
 	if passportResponse.Code != "Success" {
-		log.Printf("[ERROR] 获取 token 失败: %v", err)
-		return errors.New(passportResponse.Msg)
+		log.Printf("[ERROR] 获取 token 失败: %s %s", passportResponse.Code, passportResponse.Msg)
+		return newAPIError("获取token", passportResponse.Code, passportResponse.Msg)
 	}
 	c.setToken(passportResponse.Data.Token)
 	log.Printf("[INFO] 用户%s登录成功，获取到token", c.username)
+	if c.tokenHook != nil {
+		c.tokenHook(passportResponse.Data.Token, time.Now())
+	}
 	return nil
 }
 
-func (c *AutoDLClient) GetInstances() ([]models.Instance, error) {
+// Captcha 是登录需要的图形验证码
+type Captcha struct {
+	ID    string
+	Image []byte
+}
+
+// GetCaptchaContext 获取一张新的图形验证码
+func (c *AutoDLClient) GetCaptchaContext(ctx context.Context) (*Captcha, error) {
+	var response models.CaptchaResponse
+	err := c.doRequest(ctx, http.MethodGet, CaptchaPath, nil, &response)
+	if err != nil {
+		return nil, fmt.Errorf("获取验证码请求失败: %w", err)
+	}
+	if response.Code != "Success" {
+		return nil, newAPIError("获取验证码", response.Code, response.Msg)
+	}
+
+	// 图片可能带有 data:image/png;base64, 前缀
+	encoded := response.Data.Image
+	if i := strings.Index(encoded, ","); i >= 0 {
+		encoded = encoded[i+1:]
+	}
+	image, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("解析验证码图片失败: %v", err)
+	}
+	return &Captcha{ID: response.Data.ID, Image: image}, nil
+}
+
+// SendSMSCodeContext 向账号手机号发送登录短信验证码
+func (c *AutoDLClient) SendSMSCodeContext(ctx context.Context) error {
+	request := models.SendSMSRequest{
+		Phone:     c.username,
+		PhoneArea: "+86",
+	}
+	var response models.BaseResponse
+	err := c.doRequest(ctx, http.MethodPost, SendSMSPath, request, &response)
+	if err != nil {
+		return fmt.Errorf("发送短信验证码请求失败: %w", err)
+	}
+	if response.Code != "Success" {
+		return newAPIError("发送短信验证码", response.Code, response.Msg)
+	}
+	log.Printf("[INFO] 用户%s已发送短信验证码", c.username)
+	return nil
+}
+
+// doRequest 发送无需登录的请求并解析响应体，不依赖响应的Content-Type
+func (c *AutoDLClient) doRequest(ctx context.Context, method, path string, body, result interface{}) error {
+	req := c.client.R().SetContext(ctx)
+	if body != nil {
+		req.SetBody(body)
+	}
+	resp, err := req.Execute(method, path)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode() >= http.StatusInternalServerError {
+		return &ServerError{StatusCode: resp.StatusCode()}
+	}
+	if err := json.Unmarshal(resp.Body(), result); err != nil {
+		return fmt.Errorf("解析响应失败: %v", err)
+	}
+	return nil
+}
+
+// ensureToken 返回当前token，尚未登录时先登录
+func (c *AutoDLClient) ensureToken(ctx context.Context) (string, error) {
 	token := c.getToken()
-	if token == "" {
-		log.Printf("[INFO] 用户%stoken不存在，重新登录", c.username)
-		if err := c.Login(); err != nil {
-			return nil, err
+	if token != "" {
+		return token, nil
+	}
+	log.Printf("[INFO] 用户%stoken不存在，重新登录", c.username)
+	return c.refreshToken(ctx, "")
+}
+
+// refreshToken 在staleToken失效后重新登录，并发调用时只有一个会真正请求登录
+func (c *AutoDLClient) refreshToken(ctx context.Context, staleToken string) (string, error) {
+	c.loginMutex.Lock()
+	defer c.loginMutex.Unlock()
+
+	// 等待锁期间其他请求已经完成了重新登录
+	if token := c.getToken(); token != "" && token != staleToken {
+		return token, nil
+	}
+	if err := c.LoginContext(ctx); err != nil {
+		return "", err
+	}
+	return c.getToken(), nil
+}
+
+// sendWithToken 发送一次请求并解析响应，返回token是否失效
+func (c *AutoDLClient) sendWithToken(ctx context.Context, method, path, token string, body, result interface{}) (bool, error) {
+	req := c.client.R().SetContext(ctx).SetHeader("authorization", token)
+	if body != nil {
+		req.SetBody(body)
+	}
+	resp, err := req.Execute(method, path)
+	if err != nil {
+		return false, err
+	}
+	if resp.StatusCode() == http.StatusUnauthorized || resp.StatusCode() == http.StatusForbidden {
+		return true, nil
+	}
+	if resp.StatusCode() >= http.StatusInternalServerError {
+		return false, &ServerError{StatusCode: resp.StatusCode()}
+	}
+
+	var base models.BaseResponse
+	if err := json.Unmarshal(resp.Body(), &base); err != nil {
+		return false, fmt.Errorf("解析响应失败: %v", err)
+	}
+	if base.Code == AuthorizeFailedCode {
+		return true, nil
+	}
+	if result != nil {
+		if err := json.Unmarshal(resp.Body(), result); err != nil {
+			return false, fmt.Errorf("解析响应失败: %v", err)
 		}
-		token = c.getToken()
+	}
+	return false, nil
+}
+
+// doAuthRequest 发送需要登录的请求，token失效时重新登录一次并重放请求
+func (c *AutoDLClient) doAuthRequest(ctx context.Context, method, path string, body, result interface{}) error {
+	token, err := c.ensureToken(ctx)
+	if err != nil {
+		return err
 	}
 
+	authFailed, err := c.sendWithToken(ctx, method, path, token, body, result)
+	if err != nil {
+		return err
+	}
+	if !authFailed {
+		return nil
+	}
+
+	log.Printf("[INFO] 用户%s登录过期，重新登录", c.username)
+	token, err = c.refreshToken(ctx, token)
+	if err != nil {
+		return err
+	}
+	authFailed, err = c.sendWithToken(ctx, method, path, token, body, result)
+	if err != nil {
+		return err
+	}
+	if authFailed {
+		return newAPIError("登录", AuthorizeFailedCode, "登录状态无效，请检查用户名和密码")
+	}
+	return nil
+}
+
+// InstanceFilter 查询实例时的服务端过滤条件，零值表示不过滤
+type InstanceFilter struct {
+	Status     []string
+	ChargeType []string
+	DateFrom   string
+	DateTo     string
+}
+
+// ListInstances 查询一页实例，pageIndex从1开始
+func (c *AutoDLClient) ListInstances(filter InstanceFilter, pageIndex, pageSize int) (*models.InstancePage, error) {
+	return c.ListInstancesContext(context.Background(), filter, pageIndex, pageSize)
+}
+
+func (c *AutoDLClient) ListInstancesContext(ctx context.Context, filter InstanceFilter, pageIndex, pageSize int) (*models.InstancePage, error) {
 	instanceRequest := models.InstanceRequest{
-		DateFrom:   "",
-		DateTo:     "",
-		PageIndex:  1,
-		PageSize:   10,
-		Status:     []string{},
-		ChargeType: []string{},
+		DateFrom:   filter.DateFrom,
+		DateTo:     filter.DateTo,
+		PageIndex:  pageIndex,
+		PageSize:   pageSize,
+		Status:     filter.Status,
+		ChargeType: filter.ChargeType,
+	}
+	// 接口要求传空数组而不是null
+	if instanceRequest.Status == nil {
+		instanceRequest.Status = []string{}
+	}
+	if instanceRequest.ChargeType == nil {
+		instanceRequest.ChargeType = []string{}
 	}
 
 	var instanceResponse models.InstanceResponse
-	_, err := c.client.R().
-		SetHeader("authorization", token).
-		SetBody(instanceRequest).
-		SetResult(&instanceResponse).
-		Post(InstancePath)
-
+	err := c.doAuthRequest(ctx, http.MethodPost, InstancePath, instanceRequest, &instanceResponse)
 	if err != nil {
+		log.Printf("[ERROR] 查询实例请求失败: %v", err)
 		return nil, err
 	}
-	// check if token valid
-	if instanceResponse.Code == "AuthorizeFailed" {
-		// re-login
-		log.Printf("[INFO] 用户%s登录过期，重新登录", c.username)
-		if err := c.Login(); err != nil {
-			return nil, err
-		}
-		_, err := c.client.R().
-			SetHeader("authorization", c.getToken()).
-			SetBody(instanceRequest).
-			SetResult(&instanceResponse).
-			Post(InstancePath)
 
+	if instanceResponse.Code != "Success" {
+		log.Printf("[ERROR] 查询实例失败: %s %s", instanceResponse.Code, instanceResponse.Msg)
+		return nil, newAPIError("查询实例", instanceResponse.Code, instanceResponse.Msg)
+	}
+	return &instanceResponse.Data, nil
+}
+
+// ListAllInstances 逐页查询，直到取得所有符合条件的实例
+func (c *AutoDLClient) ListAllInstances(filter InstanceFilter) ([]models.Instance, error) {
+	return c.ListAllInstancesContext(context.Background(), filter)
+}
+
+func (c *AutoDLClient) ListAllInstancesContext(ctx context.Context, filter InstanceFilter) ([]models.Instance, error) {
+	var instances []models.Instance
+	for pageIndex := 1; ; pageIndex++ {
+		page, err := c.ListInstancesContext(ctx, filter, pageIndex, InstancePageSize)
 		if err != nil {
-			log.Printf("[ERROR] 查询实例请求失败: %v", err)
 			return nil, err
 		}
+		instances = append(instances, page.List...)
+
+		// 只用本地的页码判断，不依赖接口回显的 page_index
+		pageSize := InstancePageSize
+		if page.PageSize > 0 && page.PageSize < pageSize {
+			pageSize = page.PageSize
+		}
+		if len(page.List) < pageSize {
+			break
+		}
+		if total := pageCount(page, pageSize); total > 0 && pageIndex >= total {
+			break
+		}
 	}
 
-	if instanceResponse.Code != "Success" {
-		log.Printf("[ERROR] 查询实例失败: %v", err)
-		return nil, errors.New(instanceResponse.Msg)
+	log.Printf("[INFO] 用户%s查询实例成功，共%d个", c.username, len(instances))
+	return instances, nil
+}
+
+// pageCount 返回接口报告的总页数，未返回 max_page 时按 result_total 计算，都没有时返回0
+func pageCount(page *models.InstancePage, pageSize int) int {
+	if page.MaxPage > 0 {
+		return page.MaxPage
+	}
+	if page.ResultTotal > 0 {
+		return (page.ResultTotal + pageSize - 1) / pageSize
 	}
+	return 0
+}
+
+func (c *AutoDLClient) GetInstances() ([]models.Instance, error) {
+	return c.GetInstancesContext(context.Background())
+}
 
-	log.Printf("[INFO] 用户%s查询实例成功", c.username)
-	return instanceResponse.Data.List, nil
+func (c *AutoDLClient) GetInstancesContext(ctx context.Context) ([]models.Instance, error) {
+	return c.ListAllInstancesContext(ctx, InstanceFilter{})
 }
 
-func (c *AutoDLClient) GetGPUStatus() (string, error) {
-	instances, err := c.GetInstances()
+// GetInstanceContext 查询指定UUID的实例
+func (c *AutoDLClient) GetInstanceContext(ctx context.Context, uuid string) (*models.Instance, error) {
+	instances, err := c.GetInstancesContext(ctx)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	for i := range instances {
+		if instances[i].UUID == uuid {
+			return &instances[i], nil
+		}
+	}
+	return nil, fmt.Errorf("实例 %s 不存在: %w", uuid, ErrInstanceNotFound)
+}
 
+// WaitForStatus 轮询实例直到状态变为target，超时返回 ErrStatusTimeout
+func (c *AutoDLClient) WaitForStatus(uuid, target string, timeout time.Duration) (*models.Instance, error) {
+	return c.WaitForStatusContext(context.Background(), uuid, target, timeout)
+}
+
+func (c *AutoDLClient) WaitForStatusContext(ctx context.Context, uuid, target string, timeout time.Duration) (*models.Instance, error) {
+	deadline := time.Now().Add(timeout)
+	ticker := time.NewTicker(c.statusPollInterval)
+	defer ticker.Stop()
+
+	var lastStatus string
+	for {
+		instance, err := c.GetInstanceContext(ctx, uuid)
+		if err != nil && !IsTemporary(err) {
+			return nil, err
+		}
+		if err == nil {
+			if instance.Status == target {
+				return instance, nil
+			}
+			if instance.Status != lastStatus {
+				log.Printf("[INFO] 用户%s实例 %s 当前状态: %s", c.username, uuid, instance.Status)
+				lastStatus = instance.Status
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return instance, fmt.Errorf("实例 %s 在%s内未变为%s，当前状态：%s: %w",
+				uuid, timeout, target, lastStatus, ErrStatusTimeout)
+		}
+		select {
+		case <-ctx.Done():
+			return instance, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// FormatInstance 把实例信息渲染成多行文本，接口未返回的字段不显示，alias 为空时不显示别名
+func FormatInstance(instance models.Instance, alias string) string {
 	var result string
-	for i, instance := range instances {
-		result += fmt.Sprintf("机器: %s-%s\n", instance.RegionName, instance.MachineAlias)
-		result += "UUID: " + instance.UUID + "\n"
+	result += fmt.Sprintf("机器: %s-%s", instance.RegionName, instance.MachineAlias)
+	if alias != "" {
+		result += "（" + alias + "）"
+	}
+	result += "\n"
+	result += "UUID: " + instance.UUID + "\n"
+	if instance.Status != "" {
+		result += "状态: " + StatusName(instance.Status) + "\n"
+	}
+	if instance.GpuType != "" {
+		result += fmt.Sprintf("GPU: %s %d/%d\n", instance.GpuType, instance.GpuIdleNum, instance.GpuAllNum)
+	} else {
 		result += fmt.Sprintf("GPU: %d/%d\n", instance.GpuIdleNum, instance.GpuAllNum)
-		result += getReleaseTime(instance.StoppedAt.Time)
-		if i < len(instances)-1 {
-			result += "----------------\n"
+	}
+	if instance.ChargeType != "" || instance.PaygPrice > 0 {
+		result += "计费: " + chargeTypeName(instance.ChargeType)
+		if instance.PaygPrice > 0 {
+			result += fmt.Sprintf(" ¥%.2f/小时", HourlyPrice(instance))
+		}
+		result += "\n"
+	}
+	if instance.Image != "" {
+		result += "镜像: " + instance.Image + "\n"
+	}
+	if instance.DataDiskSize > 0 {
+		result += "数据盘: " + formatDiskSize(float64(instance.DataDiskSize))
+		if instance.ExpandDiskSize > 0 {
+			result += fmt.Sprintf("（扩容%s）", formatDiskSize(float64(instance.ExpandDiskSize)))
 		}
+		result += "\n"
+	}
+	if instance.Status == models.StatusRunning && instance.StartedAt.Valid {
+		result += "开机时间: " + formatTimestamp(instance.StartedAt.Time) + "\n"
 	}
-	return result, nil
+	result += getReleaseTime(instance.StoppedAt.Time)
+	return result
+}
+
+// HourlyPrice 返回实例按量计费的每小时价格，单位为元
+func HourlyPrice(instance models.Instance) float64 {
+	return float64(instance.PaygPrice) / 1000
+}
+
+// StatusName 返回实例状态的中文名称
+func StatusName(status string) string {
+	switch status {
+	case models.StatusRunning:
+		return "运行中"
+	case models.StatusStarting:
+		return "开机中"
+	case models.StatusShutdown:
+		return "已关机"
+	case models.StatusShuttingDown:
+		return "关机中"
+	}
+	return status
+}
+
+func chargeTypeName(chargeType string) string {
+	switch chargeType {
+	case models.ChargeTypePayg:
+		return "按量计费"
+	case models.ChargeTypeDaily:
+		return "包日"
+	case models.ChargeTypeWeekly:
+		return "包周"
+	case models.ChargeTypeMonthly:
+		return "包月"
+	case models.ChargeTypeYearly:
+		return "包年"
+	}
+	return chargeType
+}
+
+func formatDiskSize(bytes float64) string {
+	return fmt.Sprintf("%.0fGB", bytes/(1<<30))
+}
+
+func formatTimestamp(timestamp string) string {
+	t, err := parseTimestamp(timestamp)
+	if err != nil {
+		return timestamp
+	}
+	return t.Format("2006-01-02 15:04")
 }
 
 func (c *AutoDLClient) PowerOn(uuid string, useCPU bool) error {
+	return c.PowerOnContext(context.Background(), uuid, useCPU)
+}
+
+func (c *AutoDLClient) PowerOnContext(ctx context.Context, uuid string, useCPU bool) error {
 	if uuid == "" {
 		return errors.New("实例UUID不能为空")
 	}
@@ -195,17 +582,12 @@ func (c *AutoDLClient) PowerOn(uuid string, useCPU bool) error {
 	}
 
 	var response models.PowerResponse
-
-	_, err := c.client.R().
-		SetHeader("authorization", c.getToken()).
-		SetBody(body).
-		SetResult(&response).
-		Post(PowerOnPath)
+	err := c.doAuthRequest(ctx, http.MethodPost, PowerOnPath, body, &response)
 	if err != nil {
-		return fmt.Errorf("开机请求失败: %v", err)
+		return fmt.Errorf("开机请求失败: %w", err)
 	}
 	if response.Code != "Success" {
-		return fmt.Errorf("开机失败: %s", response.Msg)
+		return newAPIError("开机", response.Code, response.Msg)
 	}
 
 	log.Printf("[INFO] 用户%s实例 %s 开机成功", c.username, uuid)
@@ -213,6 +595,10 @@ func (c *AutoDLClient) PowerOn(uuid string, useCPU bool) error {
 }
 
 func (c *AutoDLClient) PowerOff(uuid string) error {
+	return c.PowerOffContext(context.Background(), uuid)
+}
+
+func (c *AutoDLClient) PowerOffContext(ctx context.Context, uuid string) error {
 	if uuid == "" {
 		return errors.New("实例UUID不能为空")
 	}
@@ -221,16 +607,12 @@ func (c *AutoDLClient) PowerOff(uuid string) error {
 		"instance_uuid": uuid,
 	}
 	var response models.PowerResponse
-	_, err := c.client.R().
-		SetHeader("authorization", c.getToken()).
-		SetBody(body).
-		SetResult(&response).
-		Post(PowerOffPath)
+	err := c.doAuthRequest(ctx, http.MethodPost, PowerOffPath, body, &response)
 	if err != nil {
-		return fmt.Errorf("关机请求失败: %v", err)
+		return fmt.Errorf("关机请求失败: %w", err)
 	}
 	if response.Code != "Success" {
-		return fmt.Errorf("关机失败: %s", response.Msg)
+		return newAPIError("关机", response.Code, response.Msg)
 	}
 
 	log.Printf("[INFO] 用户%s实例 %s 关机成功", c.username, uuid)
@@ -238,24 +620,17 @@ func (c *AutoDLClient) PowerOff(uuid string) error {
 }
 
 func (c *AutoDLClient) GetBalance() (float64, error) {
-	token := c.getToken()
-	if token == "" {
-		log.Printf("[INFO] 用户%stoken不存在，重新登录", c.username)
-		if err := c.Login(); err != nil {
-			return 0, err
-		}
-		token = c.getToken()
-	}
+	return c.GetBalanceContext(context.Background())
+}
+
+func (c *AutoDLClient) GetBalanceContext(ctx context.Context) (float64, error) {
 	var response models.WalletResponse
-	_, err := c.client.R().
-		SetHeader("authorization", token).
-		SetResult(&response).
-		Get(BalancePath)
+	err := c.doAuthRequest(ctx, http.MethodGet, BalancePath, nil, &response)
 	if err != nil {
-		return 0, fmt.Errorf("获取余额请求失败: %v", err)
+		return 0, fmt.Errorf("获取余额请求失败: %w", err)
 	}
 	if response.Code != "Success" {
-		return 0, fmt.Errorf("获取余额失败: %s", response.Msg)
+		return 0, newAPIError("获取余额", response.Code, response.Msg)
 	}
 	log.Printf("[INFO] 用户%s获取余额成功", c.username)
 	balance := float64(response.Data.Assets) / 1000
@@ -268,17 +643,43 @@ func HashPassword(rawPassword string) string {
 	return hex.EncodeToString(hasher.Sum(nil))
 }
 
+// ReleaseTime 返回实例关机后将被释放的时间，关机时间无法解析时返回false
+func ReleaseTime(instance models.Instance) (time.Time, bool) {
+	stoppedAt, err := parseTimestamp(instance.StoppedAt.Time)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return stoppedAt.Add(ReleaseAfter), true
+}
+
+// StartedTime 返回实例本次开机的时间，实例未开机或时间无法解析时返回false
+func StartedTime(instance models.Instance) (time.Time, bool) {
+	if !instance.StartedAt.Valid {
+		return time.Time{}, false
+	}
+	startedAt, err := parseTimestamp(instance.StartedAt.Time)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return startedAt, true
+}
+
+// parseTimestamp 解析接口返回的带时区时间
+func parseTimestamp(timestamp string) (time.Time, error) {
+	return time.Parse(time.RFC3339, timestamp)
+}
+
 func getReleaseTime(stoppedTime string) string {
 	result := "释放时间："
-	stoppedAt, err := time.Parse("2006-01-02T15:04:05+08:00", stoppedTime)
+	stoppedAt, err := parseTimestamp(stoppedTime)
 	if err != nil {
 		return result + "解析失败"
 	}
-	releaseTime := stoppedAt.Add(15 * 24 * time.Hour).Sub(time.Now())
+	releaseTime := stoppedAt.Add(ReleaseAfter).Sub(time.Now())
 	if releaseTime > 0 {
 		result += fmt.Sprintf("%s后释放\n", formatDuration(releaseTime))
 	} else {
-		result += "已释放"
+		result += "已释放\n"
 	}
 	return result
 }
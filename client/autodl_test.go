@@ -1,14 +1,21 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"autodl_bot/models"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func setupTestServer(t *testing.T) (*httptest.Server, *AutoDLClient) {
@@ -149,18 +156,6 @@ func TestGetInstances(t *testing.T) {
 	t.Logf("Get instances completed, instances: %v", instances)
 }
 
-func TestGetGPUStatus(t *testing.T) {
-	server, client := setupTestServer(t)
-	defer server.Close()
-
-	status, err := client.GetGPUStatus()
-	assert.NoError(t, err)
-	assert.Contains(t, status, "test-machine")
-	assert.Contains(t, status, "test-region")
-
-	t.Logf("Get GPU status completed, status: %s", status)
-}
-
 func TestHashPassword(t *testing.T) {
 	hash := HashPassword("testpass")
 	assert.NotEmpty(t, hash)
@@ -201,3 +196,379 @@ func TestAuthorizeFailedRetry(t *testing.T) {
 	assert.Len(t, instances, 1)
 	assert.Equal(t, "test-token", client.getToken())
 }
+
+func TestPowerOnReloginAfterAuthFailure(t *testing.T) {
+	var logins int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/new_login":
+			atomic.AddInt32(&logins, 1)
+			handleLogin(t, w, r)
+		case "/passport":
+			handlePassport(t, w, r)
+		case "/instance/power_on":
+			if r.Header.Get("authorization") != "test-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			json.NewEncoder(w).Encode(models.PowerResponse{Code: "Success", Msg: "Success"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := NewAutoDLClient("testuser", "testpass")
+	client.client.SetBaseURL(server.URL)
+	client.setToken("expired-token")
+
+	err := client.PowerOn("test-uuid", true)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&logins))
+	assert.Equal(t, "test-token", client.getToken())
+}
+
+func TestConcurrentReloginOnlyOnce(t *testing.T) {
+	var logins int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/new_login":
+			atomic.AddInt32(&logins, 1)
+			handleLogin(t, w, r)
+		case "/passport":
+			handlePassport(t, w, r)
+		case "/wallet":
+			if r.Header.Get("authorization") != "test-token" {
+				json.NewEncoder(w).Encode(models.BaseResponse{Code: "AuthorizeFailed"})
+				return
+			}
+			json.NewEncoder(w).Encode(models.WalletResponse{Code: "Success"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := NewAutoDLClient("testuser", "testpass")
+	client.client.SetBaseURL(server.URL)
+	client.setToken("expired-token")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.GetBalance()
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&logins))
+}
+
+func TestListAllInstancesPagination(t *testing.T) {
+	tests := []struct {
+		name         string
+		total        int
+		omitMaxPage  bool
+		omitTotal    bool
+		echoIndex    int // 非0时接口总是回显这个page_index
+		wantRequests int
+	}{
+		{name: "max_page", total: 45, wantRequests: 3},
+		{name: "缺少max_page时按result_total计算", total: 45, omitMaxPage: true, wantRequests: 3},
+		{name: "都缺少时查询到不满一页为止", total: 45, omitMaxPage: true, omitTotal: true, wantRequests: 3},
+		{name: "最后一页刚好满时按max_page结束", total: 40, wantRequests: 2},
+		{name: "都缺少且最后一页刚好满时查询到空页为止", total: 40, omitMaxPage: true, omitTotal: true, wantRequests: 3},
+		{name: "回显的page_index错误", total: 45, echoIndex: 1, wantRequests: 3},
+		{name: "回显的page_index错误且缺少max_page", total: 45, omitMaxPage: true, echoIndex: 1, wantRequests: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests []models.InstanceRequest
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var req models.InstanceRequest
+				err := json.NewDecoder(r.Body).Decode(&req)
+				assert.NoError(t, err)
+				requests = append(requests, req)
+
+				total := tt.total
+				page := models.InstancePage{
+					PageIndex:   req.PageIndex,
+					PageSize:    req.PageSize,
+					MaxPage:     (total + req.PageSize - 1) / req.PageSize,
+					ResultTotal: total,
+				}
+				if tt.echoIndex != 0 {
+					page.PageIndex = tt.echoIndex
+				}
+				if tt.omitMaxPage {
+					page.MaxPage = 0
+				}
+				if tt.omitTotal {
+					page.ResultTotal = 0
+				}
+				for i := (req.PageIndex - 1) * req.PageSize; i < total && i < req.PageIndex*req.PageSize; i++ {
+					page.List = append(page.List, models.Instance{UUID: fmt.Sprintf("uuid-%d", i)})
+				}
+				json.NewEncoder(w).Encode(models.InstanceResponse{Code: "Success", Data: page})
+			}))
+			defer server.Close()
+
+			client := NewAutoDLClient("testuser", "testpass")
+			client.client.SetBaseURL(server.URL)
+			client.setToken("test-token")
+
+			filter := InstanceFilter{
+				Status:     []string{"running"},
+				ChargeType: []string{"payg"},
+				DateFrom:   "2024-11-01",
+			}
+			instances, err := client.ListAllInstances(filter)
+			assert.NoError(t, err)
+			require.Len(t, instances, tt.total)
+			assert.Equal(t, fmt.Sprintf("uuid-%d", tt.total-1), instances[tt.total-1].UUID)
+
+			assert.Len(t, requests, tt.wantRequests)
+			for i, req := range requests {
+				assert.Equal(t, i+1, req.PageIndex)
+				assert.Equal(t, []string{"running"}, req.Status)
+				assert.Equal(t, []string{"payg"}, req.ChargeType)
+				assert.Equal(t, "2024-11-01", req.DateFrom)
+			}
+		})
+	}
+}
+
+func TestContextCancellation(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client := NewAutoDLClient("testuser", "testpass")
+	client.client.SetBaseURL(server.URL)
+	client.setToken("test-token")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := client.GetBalanceContext(ctx)
+	assert.Error(t, err)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestWaitForStatus(t *testing.T) {
+	var polls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := models.StatusStarting
+		if atomic.AddInt32(&polls, 1) >= 3 {
+			status = models.StatusRunning
+		}
+		json.NewEncoder(w).Encode(models.InstanceResponse{
+			Code: "Success",
+			Data: models.InstancePage{
+				List: []models.Instance{{UUID: "test-uuid", Status: status}},
+			},
+		})
+	}))
+	defer server.Close()
+
+	client := NewAutoDLClient("testuser", "testpass")
+	client.client.SetBaseURL(server.URL)
+	client.setToken("test-token")
+	client.statusPollInterval = 10 * time.Millisecond
+
+	instance, err := client.WaitForStatus("test-uuid", models.StatusRunning, time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, models.StatusRunning, instance.Status)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&polls))
+
+	_, err = client.WaitForStatus("test-uuid", models.StatusShutdown, 50*time.Millisecond)
+	assert.ErrorIs(t, err, ErrStatusTimeout)
+
+	_, err = client.WaitForStatus("missing-uuid", models.StatusRunning, time.Second)
+	assert.ErrorIs(t, err, ErrInstanceNotFound)
+}
+
+func TestInstanceDetailsLenientDecoding(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{
+			"code": "Success",
+			"data": {
+				"list": [{
+					"machine_alias": "test-machine",
+					"region_name": "test-region",
+					"uuid": "test-uuid",
+					"status": "running",
+					"gpu_all_num": 4,
+					"gpu_idle_num": 1,
+					"snapshot_gpu_alias_name": "RTX 4090",
+					"payg_price": "2180",
+					"charge_type": "payg",
+					"image": "pytorch/2.1.0",
+					"data_disk_size": 53687091200,
+					"expand_data_disk": null,
+					"started_at": "2024-11-24T09:30:00+08:00",
+					"stopped_at": {"time": "2024-11-20T16:54:09+08:00", "valid": true},
+					"some_new_field": {"nested": [1, 2, 3]}
+				}, {
+					"uuid": "bare-uuid",
+					"payg_price": "unknown",
+					"stopped_at": null
+				}]
+			}
+		}`))
+	}))
+	defer server.Close()
+
+	client := NewAutoDLClient("testuser", "testpass")
+	client.client.SetBaseURL(server.URL)
+	client.setToken("test-token")
+
+	instances, err := client.GetInstances()
+	assert.NoError(t, err)
+	assert.Len(t, instances, 2)
+
+	instance := instances[0]
+	assert.Equal(t, "RTX 4090", instance.GpuType)
+	assert.InDelta(t, 2.18, HourlyPrice(instance), 1e-9)
+	assert.Equal(t, models.ChargeTypePayg, instance.ChargeType)
+	assert.True(t, instance.StartedAt.Valid)
+	assert.Equal(t, "2024-11-20T16:54:09+08:00", instance.StoppedAt.Time)
+	assert.Zero(t, instance.ExpandDiskSize)
+
+	text := FormatInstance(instance, "")
+	assert.Contains(t, text, "状态: 运行中")
+	assert.Contains(t, text, "GPU: RTX 4090 1/4")
+	assert.Contains(t, text, "按量计费 ¥2.18/小时")
+	assert.Contains(t, text, "镜像: pytorch/2.1.0")
+	assert.Contains(t, text, "数据盘: 50GB")
+	assert.Contains(t, text, "开机时间: 2024-11-24 09:30")
+
+	startedAt, ok := StartedTime(instance)
+	assert.True(t, ok)
+	assert.Equal(t, int64(1732411800), startedAt.Unix())
+	_, ok = StartedTime(instances[1])
+	assert.False(t, ok)
+
+	assert.Equal(t, "bare-uuid", instances[1].UUID)
+	assert.Zero(t, instances[1].PaygPrice)
+	assert.False(t, instances[1].StoppedAt.Valid)
+}
+
+func TestRestoredTokenAndHook(t *testing.T) {
+	server, client := setupTestServer(t)
+	defer server.Close()
+
+	var savedToken string
+	client.SetTokenHook(func(token string, acquiredAt time.Time) {
+		savedToken = token
+		assert.WithinDuration(t, time.Now(), acquiredAt, time.Second)
+	})
+
+	// 恢复的token有效时不需要重新登录
+	client.SetToken("test-token")
+	_, err := client.GetInstances()
+	assert.NoError(t, err)
+	assert.Empty(t, savedToken)
+
+	// 恢复的token被拒绝后重新登录并通知保存新token
+	client.SetToken("stale-token")
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/new_login":
+			handleLogin(t, w, r)
+		case "/passport":
+			handlePassport(t, w, r)
+		case "/instance":
+			if r.Header.Get("authorization") != "test-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			handleInstance(t, w, r)
+		}
+	})
+	_, err = client.GetInstances()
+	assert.NoError(t, err)
+	assert.Equal(t, "test-token", savedToken)
+}
+
+func TestLoginFailureReturnsAPIError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(models.BaseResponse{Code: "SomeLoginError", Msg: "请输入验证码"})
+	}))
+	defer server.Close()
+
+	client := NewAutoDLClient("testuser", "testpass")
+	client.client.SetBaseURL(server.URL)
+
+	err := client.Login()
+	assert.EqualError(t, err, "登录失败: 请输入验证码")
+	var apiErr *APIError
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, "SomeLoginError", apiErr.Code)
+	assert.Empty(t, client.getToken())
+}
+
+func TestLoginWithVerification(t *testing.T) {
+	var smsSent int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case CaptchaPath:
+			assert.Equal(t, http.MethodGet, r.Method)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"code": "Success",
+				"data": map[string]interface{}{"id": "picture-1", "image": "data:image/png;base64,aW1hZ2U="},
+			})
+		case SendSMSPath:
+			var req models.SendSMSRequest
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			assert.Equal(t, "testuser", req.Phone)
+			atomic.AddInt32(&smsSent, 1)
+			json.NewEncoder(w).Encode(models.BaseResponse{Code: "Success"})
+		case LoginPATH:
+			var req models.LoginRequest
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			switch {
+			case req.PictureID == nil && req.VCode == "":
+				json.NewEncoder(w).Encode(models.BaseResponse{Code: CaptchaRequiredCode, Msg: "请输入图形验证码"})
+			case req.PictureID == "picture-1" && req.VCode == "abcd":
+				json.NewEncoder(w).Encode(models.BaseResponse{Code: SMSCodeRequiredCode, Msg: "请输入短信验证码"})
+			case req.PictureID == nil && req.VCode == "123456":
+				json.NewEncoder(w).Encode(map[string]interface{}{"code": "Success", "data": map[string]string{"ticket": "test-ticket"}})
+			default:
+				json.NewEncoder(w).Encode(models.BaseResponse{Code: CaptchaRequiredCode, Msg: "验证码错误"})
+			}
+		case PassportPath:
+			handlePassport(t, w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := NewAutoDLClient("testuser", "testpass")
+	client.client.SetBaseURL(server.URL)
+
+	err := client.Login()
+	assert.ErrorIs(t, err, ErrCaptchaRequired)
+	assert.False(t, IsTemporary(err))
+
+	captcha, err := client.GetCaptchaContext(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "picture-1", captcha.ID)
+	assert.Equal(t, []byte("image"), captcha.Image)
+
+	err = client.LoginWithVerificationContext(context.Background(), LoginVerification{PictureID: captcha.ID, VCode: "abcd"})
+	assert.ErrorIs(t, err, ErrSMSCodeRequired)
+
+	require.NoError(t, client.SendSMSCodeContext(context.Background()))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&smsSent))
+	err = client.LoginWithVerificationContext(context.Background(), LoginVerification{VCode: "123456"})
+	require.NoError(t, err)
+	assert.Equal(t, "test-token", client.getToken())
+}
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// 按错误类别划分的哨兵错误，可通过 errors.Is 判断 APIError 的类别
+var (
+	ErrAuthorizeFailed     = errors.New("登录状态无效")
+	ErrInvalidCredentials  = errors.New("用户名或密码错误")
+	ErrInsufficientBalance = errors.New("余额不足")
+	ErrInstanceNotFound    = errors.New("实例不存在")
+	ErrNoFreeGPU           = errors.New("没有空闲GPU")
+	ErrStatusTimeout       = errors.New("等待实例状态超时")
+	ErrCaptchaRequired     = errors.New("登录需要图形验证码")
+	ErrSMSCodeRequired     = errors.New("登录需要短信验证码")
+)
+
+// codeErrors 把接口返回的code映射到错误类别，未列出的code作为普通 APIError，由接口返回的msg说明原因
+var codeErrors = map[string]error{
+	AuthorizeFailedCode: ErrAuthorizeFailed,
+
+	"UserNotExist":      ErrInvalidCredentials,
+	"PasswordIncorrect": ErrInvalidCredentials,
+
+	CaptchaRequiredCode: ErrCaptchaRequired,
+	SMSCodeRequiredCode: ErrSMSCodeRequired,
+
+	"BalanceNotEnough": ErrInsufficientBalance,
+
+	"InstanceNotFound": ErrInstanceNotFound,
+
+	// 开机时主机上的GPU已被其他用户占用
+	"GpuNotEnough": ErrNoFreeGPU,
+}
+
+// APIError 表示AutoDL接口返回了非Success的code
+type APIError struct {
+	Op   string // 出错的操作，例如"开机"
+	Code string // 接口返回的原始code
+	Msg  string // 接口返回的原始msg
+}
+
+func newAPIError(op, code, msg string) *APIError {
+	return &APIError{Op: op, Code: code, Msg: msg}
+}
+
+func (e *APIError) Error() string {
+	msg := e.Msg
+	if msg == "" {
+		msg = e.Code
+	}
+	return fmt.Sprintf("%s失败: %s", e.Op, msg)
+}
+
+// Unwrap 返回code对应的哨兵错误，使 errors.Is(err, ErrNoFreeGPU) 等判断可用
+func (e *APIError) Unwrap() error {
+	return codeErrors[e.Code]
+}
+
+// ServerError 表示AutoDL返回了5xx状态码，此时响应体通常是网关生成的错误页而不是JSON
+type ServerError struct {
+	StatusCode int
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("AutoDL服务暂时不可用（HTTP %d）", e.StatusCode)
+}
+
+// IsTemporary 判断错误是否可能在稍后重试时消失
+func IsTemporary(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var serverErr *ServerError
+	if errors.As(err, &serverErr) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
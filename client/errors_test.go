@@ -0,0 +1,112 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"autodl_bot/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIErrorMatchesSentinel(t *testing.T) {
+	err := fmt.Errorf("wrapped: %w", newAPIError("查询实例", AuthorizeFailedCode, "登录已过期"))
+
+	assert.ErrorIs(t, err, ErrAuthorizeFailed)
+	assert.NotErrorIs(t, err, ErrInstanceNotFound)
+	assert.False(t, IsTemporary(err))
+
+	var apiErr *APIError
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, AuthorizeFailedCode, apiErr.Code)
+	assert.Equal(t, "登录已过期", apiErr.Msg)
+	assert.Equal(t, "查询实例失败: 登录已过期", apiErr.Error())
+}
+
+func TestAPIErrorUnknownCode(t *testing.T) {
+	err := newAPIError("关机", "SomethingNew", "")
+
+	assert.Nil(t, errors.Unwrap(err))
+	assert.False(t, IsTemporary(err))
+	assert.Equal(t, "关机失败: SomethingNew", err.Error())
+	assert.True(t, IsTemporary(context.DeadlineExceeded))
+}
+
+func TestPowerOnReturnsAPIError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(models.PowerResponse{
+			Code: "BalanceNotEnough",
+			Msg:  "余额不足，请充值",
+		})
+	}))
+	defer server.Close()
+
+	client := NewAutoDLClient("testuser", "testpass")
+	client.client.SetBaseURL(server.URL)
+	client.setToken("test-token")
+
+	err := client.PowerOn("test-uuid", false)
+	assert.EqualError(t, err, "开机失败: 余额不足，请充值")
+	assert.ErrorIs(t, err, ErrInsufficientBalance)
+
+	var apiErr *APIError
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, "BalanceNotEnough", apiErr.Code)
+}
+
+func TestServerErrorIsTemporary(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte("<html><body>502 Bad Gateway</body></html>"))
+	}))
+	defer server.Close()
+
+	client := NewAutoDLClient("testuser", "testpass")
+	client.client.SetBaseURL(server.URL)
+
+	// 无需登录的请求
+	err := client.Login()
+	var serverErr *ServerError
+	require.True(t, errors.As(err, &serverErr), "%v", err)
+	assert.Equal(t, http.StatusBadGateway, serverErr.StatusCode)
+	assert.True(t, IsTemporary(err))
+	assert.NotContains(t, err.Error(), "解析响应失败")
+
+	// 需要登录的请求
+	client.setToken("test-token")
+	err = client.PowerOn("test-uuid", false)
+	assert.True(t, errors.As(err, &serverErr), "%v", err)
+	assert.True(t, IsTemporary(err))
+}
+
+func TestCodeErrors(t *testing.T) {
+	tests := []struct {
+		code     string
+		sentinel error
+	}{
+		{AuthorizeFailedCode, ErrAuthorizeFailed},
+		{"UserNotExist", ErrInvalidCredentials},
+		{"PasswordIncorrect", ErrInvalidCredentials},
+		{"BalanceNotEnough", ErrInsufficientBalance},
+		{"InstanceNotFound", ErrInstanceNotFound},
+		{"GpuNotEnough", ErrNoFreeGPU},
+		{"SomethingNew", nil},
+	}
+	sentinels := []error{ErrAuthorizeFailed, ErrInvalidCredentials, ErrInsufficientBalance, ErrInstanceNotFound, ErrNoFreeGPU}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			err := fmt.Errorf("开机请求失败: %w", newAPIError("开机", tt.code, "msg"))
+			for _, sentinel := range sentinels {
+				assert.Equal(t, sentinel == tt.sentinel, errors.Is(err, sentinel), "%v", sentinel)
+			}
+			assert.False(t, IsTemporary(err))
+		})
+	}
+}
@@ -10,12 +10,18 @@ import (
 	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
+	// 内置时区数据，部署环境缺少 zoneinfo 时也能使用用户设置的时区
+	_ "time/tzdata"
 )
 
 var (
-	tokenFlag = flag.String("token", "", "telegram bot token")
+	tokenFlag   = flag.String("token", "", "telegram bot token")
+	adminsFlag  = flag.String("admins", "", "管理员的Telegram ID，多个用逗号分隔")
+	allowedFlag = flag.String("allowed", "", "允许使用Bot的Telegram ID，多个用逗号分隔，为空时不限制")
 )
 
 func setupLogger() (*os.File, error) {
@@ -53,6 +59,27 @@ func getToken() string {
 	return ""
 }
 
+// getIDs 从命令行参数或环境变量读取逗号分隔的Telegram ID列表
+func getIDs(flagValue, envName string) []int64 {
+	value := flagValue
+	if value == "" {
+		value = os.Getenv(envName)
+	}
+	var ids []int64
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			log.Fatalf("无效的Telegram ID：%s", field)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
 func getProxyClient() *http.Client {
 	proxyURL, err := url.Parse("http://127.0.0.1:7890")
 	if err != nil {
@@ -77,7 +104,10 @@ func main() {
 	defer logger.Close()
 
 	tgToken := getToken()
-	tgbot, err := bot.NewBot(tgToken, getProxyClient())
+	tgbot, err := bot.NewBot(tgToken, getProxyClient(), bot.Options{
+		Admins:       getIDs(*adminsFlag, "ADMIN_IDS"),
+		AllowedUsers: getIDs(*allowedFlag, "ALLOWED_USERS"),
+	})
 	if err != nil {
 		log.Fatalf("无法创建telegram bot: %v", err)
 	}
@@ -96,6 +126,14 @@ func main() {
 	case sig := <-sigCh:
 		log.Printf("接收到退出信号：%s", sig)
 
+		log.Println("正在等待处理中的命令完成")
+		tgbot.Stop()
+		select {
+		case <-errCh:
+		case <-time.After(time.Minute):
+			log.Println("等待超时，直接退出")
+		}
+
 		log.Println("正在保存用户配置")
 		if err := tgbot.SaveAllUserConfig(); err != nil {
 			log.Printf("保存用户配置失败：%v", err)
@@ -1,5 +1,12 @@
 package models
 
+import "time"
+
+type BaseResponse struct {
+	Code string `json:"code"`
+	Msg  string `json:"msg"`
+}
+
 type LoginRequest struct {
 	Phone     string      `json:"phone"`
 	Password  string      `json:"password"`
@@ -18,6 +25,20 @@ type LoginData struct {
 	Ticket string `json:"ticket"`
 }
 
+type CaptchaResponse struct {
+	Code string `json:"code"`
+	Data struct {
+		ID    string `json:"id"`
+		Image string `json:"image"` // base64编码的图片
+	} `json:"data"`
+	Msg string `json:"msg"`
+}
+
+type SendSMSRequest struct {
+	Phone     string `json:"phone"`
+	PhoneArea string `json:"phone_area"`
+}
+
 type PassportRequest struct {
 	Ticket string `json:"ticket"`
 }
@@ -41,23 +62,53 @@ type InstanceRequest struct {
 	ChargeType []string `json:"charge_type"`
 }
 
+// 实例状态
+const (
+	StatusRunning      = "running"
+	StatusStarting     = "starting"
+	StatusShutdown     = "shutdown"
+	StatusShuttingDown = "shutting_down"
+)
+
+// 计费方式
+const (
+	ChargeTypePayg    = "payg"
+	ChargeTypeDaily   = "daily"
+	ChargeTypeWeekly  = "weekly"
+	ChargeTypeMonthly = "monthly"
+	ChargeTypeYearly  = "yearly"
+)
+
 type Instance struct {
-	MachineAlias string `json:"machine_alias"`
-	RegionName   string `json:"region_name"`
-	GpuAllNum    int    `json:"gpu_all_num"`
-	GpuIdleNum   int    `json:"gpu_idle_num"`
-	UUID         string `json:"uuid"`
-	StoppedAt    struct {
-		Time string `json:"time"`
-	} `json:"stopped_at"`
+	MachineAlias   string     `json:"machine_alias"`
+	RegionName     string     `json:"region_name"`
+	GpuAllNum      int        `json:"gpu_all_num"`
+	GpuIdleNum     int        `json:"gpu_idle_num"`
+	UUID           string     `json:"uuid"`
+	Status         string     `json:"status"`
+	GpuType        string     `json:"snapshot_gpu_alias_name"`
+	PaygPrice      FlexNumber `json:"payg_price"` // 按量计费每小时价格，单位为0.001元
+	ChargeType     string     `json:"charge_type"`
+	Image          string     `json:"image"`
+	DataDiskSize   FlexNumber `json:"data_disk_size"`   // 单位为字节
+	ExpandDiskSize FlexNumber `json:"expand_data_disk"` // 单位为字节
+	StartedAt      NullTime   `json:"started_at"`
+	StoppedAt      NullTime   `json:"stopped_at"`
+	CreatedAt      NullTime   `json:"created_at"`
+}
+
+type InstancePage struct {
+	List        []Instance `json:"list"`
+	PageIndex   int        `json:"page_index"`
+	PageSize    int        `json:"page_size"`
+	MaxPage     int        `json:"max_page"`
+	ResultTotal int        `json:"result_total"`
 }
 
 type InstanceResponse struct {
-	Code string `json:"code"`
-	Data struct {
-		List []Instance `json:"list"`
-	} `json:"data"`
-	Msg string `json:"msg"`
+	Code string       `json:"code"`
+	Data InstancePage `json:"data"`
+	Msg  string       `json:"msg"`
 }
 
 type PowerResponse struct {
@@ -77,3 +128,70 @@ type AutoDLConfig struct {
 	Username string
 	Password string
 }
+
+// Watch 是用户订阅的GPU空闲提醒
+type Watch struct {
+	ID         int64
+	TelegramID int
+	ChatID     int64
+	Target     string // 实例UUID或机器别名
+	MinIdle    int
+	Notified   bool // 已提醒且空闲数尚未回落到阈值以下
+}
+
+// Snipe 是等待GPU空闲后自动开机的任务
+type Snipe struct {
+	ID         int64
+	TelegramID int
+	ChatID     int64
+	UUID       string
+	GPUs       int // 至少需要的空闲GPU数
+	Deadline   time.Time
+}
+
+// RefreshPolicy 是实例的自动刷新策略，在释放前DaysBefore天自动刷新释放时长
+type RefreshPolicy struct {
+	TelegramID int
+	ChatID     int64
+	UUID       string
+	DaysBefore int
+}
+
+// Schedule 是实例的定时开关机计划，Spec为cron表达式，按用户时区执行
+type Schedule struct {
+	ID         int64
+	TelegramID int
+	ChatID     int64
+	UUID       string
+	Action     string // start、startcpu 或 stop
+	Spec       string
+	NextRun    time.Time
+}
+
+// RunningInstance 记录监控到实例开始运行的时间，用于长时间运行提醒
+type RunningInstance struct {
+	TelegramID int
+	UUID       string
+	Since      time.Time
+	RemindedAt time.Time // 最近一次提醒的时间，未提醒过时为零值
+}
+
+// BalanceRecord 是定期记录的账户余额，用于统计消费
+type BalanceRecord struct {
+	Balance    float64
+	RecordedAt time.Time
+}
+
+// Job 是持久化的后台任务
+type Job struct {
+	ID         int64
+	TelegramID int
+	ChatID     int64
+	Kind       string
+	UUID       string
+	Payload    string // 任务附加参数，含义由任务类型决定
+	RunAt      time.Time
+	Attempts   int
+	LastError  string
+	CreatedAt  time.Time
+}
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+)
+
+// FlexNumber 兼容接口中以数字、数字字符串或null返回的数值字段
+type FlexNumber float64
+
+func (n *FlexNumber) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || bytes.Equal(data, []byte("null")) {
+		*n = 0
+		return nil
+	}
+	if data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		if s == "" {
+			*n = 0
+			return nil
+		}
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			// 无法识别的值按缺失处理，避免整个响应解析失败
+			*n = 0
+			return nil
+		}
+		*n = FlexNumber(v)
+		return nil
+	}
+	var v float64
+	if err := json.Unmarshal(data, &v); err != nil {
+		*n = 0
+		return nil
+	}
+	*n = FlexNumber(v)
+	return nil
+}
+
+// NullTime 对应接口中 {"time": "...", "valid": true} 形式的时间字段，也兼容直接返回字符串或null
+type NullTime struct {
+	Time  string `json:"time"`
+	Valid bool   `json:"valid"`
+}
+
+func (t *NullTime) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	*t = NullTime{}
+	if len(data) == 0 || bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	if data[0] == '"' {
+		if err := json.Unmarshal(data, &t.Time); err != nil {
+			return nil
+		}
+		t.Valid = t.Time != ""
+		return nil
+	}
+
+	var raw struct {
+		Time  string `json:"time"`
+		Valid *bool  `json:"valid"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil
+	}
+	t.Time = raw.Time
+	if raw.Valid != nil {
+		t.Valid = *raw.Valid
+	} else {
+		t.Valid = raw.Time != ""
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCronNext(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	assert.NoError(t, err)
+
+	// 2024-11-29 是周五
+	base := time.Date(2024, 11, 29, 10, 30, 0, 0, shanghai)
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"0 9 * * 1-5", time.Date(2024, 12, 2, 9, 0, 0, 0, shanghai)},
+		{"0 19 * * *", time.Date(2024, 11, 29, 19, 0, 0, 0, shanghai)},
+		{"*/15 * * * *", time.Date(2024, 11, 29, 10, 45, 0, 0, shanghai)},
+		{"30 10 * * *", time.Date(2024, 11, 30, 10, 30, 0, 0, shanghai)},
+		{"0 0 1 * *", time.Date(2024, 12, 1, 0, 0, 0, 0, shanghai)},
+		{"0 8 * * 0", time.Date(2024, 12, 1, 8, 0, 0, 0, shanghai)},
+		{"0 8 * * 7", time.Date(2024, 12, 1, 8, 0, 0, 0, shanghai)},
+		{"0 9,18 * * 6", time.Date(2024, 11, 30, 9, 0, 0, 0, shanghai)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, shanghai)},
+		// 日和周都有限制时满足其一即可
+		{"0 12 15 * 1", time.Date(2024, 12, 2, 12, 0, 0, 0, shanghai)},
+	}
+	for _, tt := range tests {
+		cron, err := ParseCron(tt.spec)
+		if assert.NoError(t, err, tt.spec) {
+			assert.True(t, tt.want.Equal(cron.Next(base)), "%s: got %s", tt.spec, cron.Next(base))
+		}
+	}
+}
+
+func TestCronNextUsesLocation(t *testing.T) {
+	shanghai, _ := time.LoadLocation("Asia/Shanghai")
+	cron, err := ParseCron("0 9 * * *")
+	assert.NoError(t, err)
+
+	now := time.Date(2024, 11, 29, 0, 0, 0, 0, time.UTC) // 上海时间 08:00
+	next := cron.Next(now.In(shanghai))
+	assert.True(t, time.Date(2024, 11, 29, 1, 0, 0, 0, time.UTC).Equal(next))
+}
+
+func TestCronNeverFires(t *testing.T) {
+	cron, err := ParseCron("0 0 30 2 *")
+	assert.NoError(t, err)
+	assert.True(t, cron.Next(time.Now()).IsZero())
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"0 9 * *",
+		"60 * * * *",
+		"0 24 * * *",
+		"0 0 0 * *",
+		"0 0 * 13 *",
+		"0 0 * * 8",
+		"0 9-5 * * *",
+		"*/0 * * * *",
+		"a * * * *",
+	} {
+		_, err := ParseCron(spec)
+		assert.Error(t, err, spec)
+	}
+}
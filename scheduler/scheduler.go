@@ -0,0 +1,261 @@
+package scheduler
+
+import (
+	"autodl_bot/models"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultPollInterval = 5 * time.Second
+	DefaultMaxAttempts  = 5
+	// DefaultJobTimeout 是单个任务执行的最长时间，刷新类任务需要等待开关机完成
+	DefaultJobTimeout = 30 * time.Minute
+
+	backoffBase = 30 * time.Second
+	backoffMax  = 30 * time.Minute
+)
+
+// Store 持久化任务，重启后未完成的任务会继续执行
+type Store interface {
+	AddJob(job *models.Job) (int64, error)
+	UpdateJob(job *models.Job) error
+	DeleteJob(id int64) error
+	DueJobs(now time.Time) ([]*models.Job, error)
+	ListJobs(tgID int) ([]*models.Job, error)
+	CancelJob(tgID int, id int64) (bool, error)
+}
+
+// Handler 执行一种任务，返回的文本会发送到创建任务的会话，返回空文本时不发送
+type Handler func(ctx context.Context, job *models.Job) (string, error)
+
+// Notifier 把任务结果发送到会话
+type Notifier func(chatID int64, text string)
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent 标记不需要重试的错误
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+func isPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+type Scheduler struct {
+	store    Store
+	notify   Notifier
+	handlers map[string]Handler
+
+	// FormatError 把最终失败的错误转换成发送给用户的文本
+	FormatError  func(error) string
+	PollInterval time.Duration
+	MaxAttempts  int
+	JobTimeout   time.Duration
+
+	wake    chan struct{}
+	mu      sync.Mutex
+	running map[int64]bool
+	wg      sync.WaitGroup
+}
+
+func New(store Store, notify Notifier) *Scheduler {
+	return &Scheduler{
+		store:        store,
+		notify:       notify,
+		handlers:     make(map[string]Handler),
+		FormatError:  func(err error) string { return err.Error() },
+		PollInterval: DefaultPollInterval,
+		MaxAttempts:  DefaultMaxAttempts,
+		JobTimeout:   DefaultJobTimeout,
+		wake:         make(chan struct{}, 1),
+		running:      make(map[int64]bool),
+	}
+}
+
+// Register 注册一种任务的处理函数，需要在 Run 之前调用
+func (s *Scheduler) Register(kind string, handler Handler) {
+	s.handlers[kind] = handler
+}
+
+// Schedule 保存任务并唤醒调度循环，RunAt为零值时立即执行
+func (s *Scheduler) Schedule(job *models.Job) (int64, error) {
+	if _, exist := s.handlers[job.Kind]; !exist {
+		return 0, fmt.Errorf("未知的任务类型：%s", job.Kind)
+	}
+	if job.RunAt.IsZero() {
+		job.RunAt = time.Now()
+	}
+	job.CreatedAt = time.Now()
+	id, err := s.store.AddJob(job)
+	if err != nil {
+		return 0, err
+	}
+	job.ID = id
+	s.wakeUp()
+	return id, nil
+}
+
+// ErrJobRunning 表示任务正在执行，不能再修改执行时间
+var ErrJobRunning = errors.New("任务正在执行")
+
+// Reschedule 修改尚未执行的任务的执行时间，任务已开始执行时返回 ErrJobRunning
+func (s *Scheduler) Reschedule(job *models.Job, runAt time.Time) error {
+	// 与 runDue 取任务互斥，保证修改后的时间不会被正在取出的旧任务覆盖
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running[job.ID] {
+		return ErrJobRunning
+	}
+	job.RunAt = runAt
+	if err := s.store.UpdateJob(job); err != nil {
+		return err
+	}
+	s.wakeUp()
+	return nil
+}
+
+// wakeUp 让调度循环立即检查到期任务
+func (s *Scheduler) wakeUp() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// List 返回用户尚未完成的任务
+func (s *Scheduler) List(tgID int) ([]*models.Job, error) {
+	return s.store.ListJobs(tgID)
+}
+
+// Cancel 取消用户尚未完成的任务，正在执行的任务会执行完本次
+func (s *Scheduler) Cancel(tgID int, id int64) (bool, error) {
+	return s.store.CancelJob(tgID, id)
+}
+
+// Run 执行到期的任务直到stop关闭，启动时会继续执行重启前未完成的任务
+func (s *Scheduler) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(s.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		s.runDue()
+		select {
+		case <-ticker.C:
+		case <-s.wake:
+		case <-stop:
+			s.wg.Wait()
+			return
+		}
+	}
+}
+
+func (s *Scheduler) runDue() {
+	for _, job := range s.takeDue() {
+		s.wg.Add(1)
+		go func(job *models.Job) {
+			defer s.wg.Done()
+			defer s.markDone(job.ID)
+			s.execute(job)
+		}(job)
+	}
+}
+
+// takeDue 取出到期且未在执行的任务并标记为执行中
+func (s *Scheduler) takeDue() []*models.Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs, err := s.store.DueJobs(time.Now())
+	if err != nil {
+		log.Printf("[ERROR] 读取到期任务失败: %v", err)
+		return nil
+	}
+	var due []*models.Job
+	for _, job := range jobs {
+		if s.running[job.ID] {
+			continue
+		}
+		s.running[job.ID] = true
+		due = append(due, job)
+	}
+	return due
+}
+
+func (s *Scheduler) markDone(id int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.running, id)
+}
+
+// execute 执行一次任务，失败时按指数退避重新安排，超过次数或遇到永久错误时放弃
+func (s *Scheduler) execute(job *models.Job) {
+	handler, exist := s.handlers[job.Kind]
+	if !exist {
+		s.finish(job, "", Permanent(fmt.Errorf("未知的任务类型：%s", job.Kind)))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.JobTimeout)
+	text, err := safeRun(ctx, handler, job)
+	cancel()
+
+	job.Attempts++
+	if err != nil && !isPermanent(err) && job.Attempts < s.MaxAttempts {
+		job.LastError = err.Error()
+		job.RunAt = time.Now().Add(backoff(job.Attempts))
+		log.Printf("[INFO] 任务 #%d 第%d次执行失败，%s后重试: %v", job.ID, job.Attempts, backoff(job.Attempts), err)
+		if err := s.store.UpdateJob(job); err != nil {
+			log.Printf("[ERROR] 更新任务 #%d 失败: %v", job.ID, err)
+		}
+		return
+	}
+	s.finish(job, text, err)
+}
+
+func (s *Scheduler) finish(job *models.Job, text string, err error) {
+	if delErr := s.store.DeleteJob(job.ID); delErr != nil {
+		log.Printf("[ERROR] 删除任务 #%d 失败: %v", job.ID, delErr)
+	}
+	if err != nil {
+		log.Printf("[ERROR] 任务 #%d (%s) 执行失败: %v", job.ID, job.Kind, err)
+		text = fmt.Sprintf("任务 #%d 执行失败：%s", job.ID, s.FormatError(err))
+	}
+	if text != "" && s.notify != nil {
+		s.notify(job.ChatID, text)
+	}
+}
+
+// safeRun 执行任务处理函数，处理函数panic时按永久错误处理
+func safeRun(ctx context.Context, handler Handler, job *models.Job) (text string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = Permanent(fmt.Errorf("任务执行异常: %v", r))
+		}
+	}()
+	return handler(ctx, job)
+}
+
+func backoff(attempt int) time.Duration {
+	d := backoffBase
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if d >= backoffMax {
+			return backoffMax
+		}
+	}
+	return d
+}
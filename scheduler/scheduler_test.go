@@ -0,0 +1,285 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"autodl_bot/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type memStore struct {
+	mu     sync.Mutex
+	nextID int64
+	jobs   map[int64]models.Job
+}
+
+func newMemStore() *memStore {
+	return &memStore{jobs: make(map[int64]models.Job)}
+}
+
+func (m *memStore) AddJob(job *models.Job) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextID++
+	job.ID = m.nextID
+	m.jobs[job.ID] = *job
+	return job.ID, nil
+}
+
+func (m *memStore) UpdateJob(job *models.Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exist := m.jobs[job.ID]; exist {
+		m.jobs[job.ID] = *job
+	}
+	return nil
+}
+
+func (m *memStore) DeleteJob(id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.jobs, id)
+	return nil
+}
+
+func (m *memStore) DueJobs(now time.Time) ([]*models.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var jobs []*models.Job
+	for _, job := range m.jobs {
+		if !job.RunAt.After(now) {
+			job := job
+			jobs = append(jobs, &job)
+		}
+	}
+	return jobs, nil
+}
+
+func (m *memStore) ListJobs(tgID int) ([]*models.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var jobs []*models.Job
+	for _, job := range m.jobs {
+		if job.TelegramID == tgID {
+			job := job
+			jobs = append(jobs, &job)
+		}
+	}
+	return jobs, nil
+}
+
+func (m *memStore) CancelJob(tgID int, id int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, exist := m.jobs[id]
+	if !exist || job.TelegramID != tgID {
+		return false, nil
+	}
+	delete(m.jobs, id)
+	return true, nil
+}
+
+func (m *memStore) get(id int64) (models.Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, exist := m.jobs[id]
+	return job, exist
+}
+
+type message struct {
+	chatID int64
+	text   string
+}
+
+func newTestScheduler(store Store) (*Scheduler, chan message) {
+	messages := make(chan message, 10)
+	s := New(store, func(chatID int64, text string) {
+		messages <- message{chatID, text}
+	})
+	s.PollInterval = 10 * time.Millisecond
+	return s, messages
+}
+
+func TestRetryWithBackoff(t *testing.T) {
+	store := newMemStore()
+	s, messages := newTestScheduler(store)
+	calls := 0
+	s.Register("flaky", func(ctx context.Context, job *models.Job) (string, error) {
+		calls++
+		if calls < 2 {
+			return "", errors.New("temporary")
+		}
+		return "done", nil
+	})
+
+	id, err := s.Schedule(&models.Job{TelegramID: 1, ChatID: 100, Kind: "flaky"})
+	assert.NoError(t, err)
+
+	job, _ := store.get(id)
+	s.execute(&job)
+	retried, exist := store.get(id)
+	assert.True(t, exist)
+	assert.Equal(t, 1, retried.Attempts)
+	assert.Equal(t, "temporary", retried.LastError)
+	assert.True(t, retried.RunAt.After(time.Now().Add(backoffBase-time.Second)))
+
+	s.execute(&retried)
+	_, exist = store.get(id)
+	assert.False(t, exist)
+	assert.Equal(t, message{100, "done"}, <-messages)
+}
+
+func TestPermanentErrorNotRetried(t *testing.T) {
+	store := newMemStore()
+	s, messages := newTestScheduler(store)
+	s.FormatError = func(err error) string { return "格式化：" + err.Error() }
+	s.Register("broken", func(ctx context.Context, job *models.Job) (string, error) {
+		return "", Permanent(errors.New("no credentials"))
+	})
+
+	id, _ := s.Schedule(&models.Job{TelegramID: 1, ChatID: 100, Kind: "broken"})
+	job, _ := store.get(id)
+	s.execute(&job)
+
+	_, exist := store.get(id)
+	assert.False(t, exist)
+	msg := <-messages
+	assert.Contains(t, msg.text, "执行失败")
+	assert.Contains(t, msg.text, "格式化：no credentials")
+}
+
+func TestGiveUpAfterMaxAttempts(t *testing.T) {
+	store := newMemStore()
+	s, messages := newTestScheduler(store)
+	s.MaxAttempts = 2
+	s.Register("flaky", func(ctx context.Context, job *models.Job) (string, error) {
+		return "", errors.New("temporary")
+	})
+
+	id, _ := s.Schedule(&models.Job{TelegramID: 1, ChatID: 100, Kind: "flaky"})
+	for i := 0; i < 2; i++ {
+		job, exist := store.get(id)
+		assert.True(t, exist)
+		s.execute(&job)
+	}
+
+	_, exist := store.get(id)
+	assert.False(t, exist)
+	assert.Contains(t, (<-messages).text, "temporary")
+}
+
+func TestPanicIsPermanent(t *testing.T) {
+	store := newMemStore()
+	s, messages := newTestScheduler(store)
+	s.Register("panic", func(ctx context.Context, job *models.Job) (string, error) {
+		panic("boom")
+	})
+
+	id, _ := s.Schedule(&models.Job{TelegramID: 1, ChatID: 100, Kind: "panic"})
+	job, _ := store.get(id)
+	s.execute(&job)
+
+	_, exist := store.get(id)
+	assert.False(t, exist)
+	assert.Contains(t, (<-messages).text, "boom")
+}
+
+func TestRunResumesStoredJobs(t *testing.T) {
+	store := newMemStore()
+	// 模拟重启前保存的任务
+	store.AddJob(&models.Job{TelegramID: 1, ChatID: 100, Kind: "echo", Payload: "resumed", RunAt: time.Now()})
+	store.AddJob(&models.Job{TelegramID: 1, ChatID: 100, Kind: "echo", Payload: "later", RunAt: time.Now().Add(time.Hour)})
+
+	s, messages := newTestScheduler(store)
+	s.Register("echo", func(ctx context.Context, job *models.Job) (string, error) {
+		return job.Payload, nil
+	})
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		s.Run(stop)
+		close(done)
+	}()
+
+	select {
+	case msg := <-messages:
+		assert.Equal(t, message{100, "resumed"}, msg)
+	case <-time.After(time.Second):
+		t.Fatal("stored job was not resumed")
+	}
+	close(stop)
+	<-done
+
+	jobs, _ := s.List(1)
+	assert.Len(t, jobs, 1)
+	assert.Equal(t, "later", jobs[0].Payload)
+}
+
+func TestScheduleUnknownKind(t *testing.T) {
+	s, _ := newTestScheduler(newMemStore())
+	_, err := s.Schedule(&models.Job{Kind: "unknown"})
+	assert.Error(t, err)
+}
+
+func TestCancel(t *testing.T) {
+	store := newMemStore()
+	s, _ := newTestScheduler(store)
+	s.Register("echo", func(ctx context.Context, job *models.Job) (string, error) {
+		return job.Payload, nil
+	})
+	id, _ := s.Schedule(&models.Job{TelegramID: 1, Kind: "echo", RunAt: time.Now().Add(time.Hour)})
+
+	ok, _ := s.Cancel(2, id)
+	assert.False(t, ok)
+	ok, _ = s.Cancel(1, id)
+	assert.True(t, ok)
+	jobs, _ := s.List(1)
+	assert.Empty(t, jobs)
+}
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, backoffBase, backoff(1))
+	assert.Equal(t, 2*backoffBase, backoff(2))
+	assert.Equal(t, backoffMax, backoff(20))
+}
+
+func TestRescheduleRunningJob(t *testing.T) {
+	store := newMemStore()
+	s, messages := newTestScheduler(store)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s.Register("slow", func(ctx context.Context, job *models.Job) (string, error) {
+		close(started)
+		<-release
+		return "done", nil
+	})
+	id, _ := s.Schedule(&models.Job{TelegramID: 1, ChatID: 100, Kind: "slow"})
+	waiting, _ := s.Schedule(&models.Job{TelegramID: 1, ChatID: 100, Kind: "slow", RunAt: time.Now().Add(time.Hour)})
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		s.Run(stop)
+		close(done)
+	}()
+	<-started
+
+	job, _ := store.get(id)
+	assert.ErrorIs(t, s.Reschedule(&job, time.Now().Add(time.Hour)), ErrJobRunning)
+	later, _ := store.get(waiting)
+	runAt := later.RunAt.Add(time.Hour)
+	assert.NoError(t, s.Reschedule(&later, runAt))
+	updated, _ := store.get(waiting)
+	assert.True(t, updated.RunAt.Equal(runAt))
+
+	close(release)
+	assert.Equal(t, message{100, "done"}, <-messages)
+	close(stop)
+	<-done
+}
@@ -3,6 +3,7 @@ package storage
 import (
 	"autodl_bot/models"
 	"database/sql"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -13,22 +14,117 @@ type UserStorage struct {
 
 var DBPath = "users.db"
 
-func NewUserStorage() (*UserStorage, error) {
-	db, err := sql.Open("sqlite3", DBPath)
-	if err != nil {
-		return nil, err
-	}
-	_, err = db.Exec(`
-	CREATE TABLE IF NOT EXISTS users (
+var schemas = []string{
+	`CREATE TABLE IF NOT EXISTS users (
 		telegram_id INTEGER PRIMARY KEY,
 		username TEXT NOT NULL,
 		password TEXT NOT NULL
-	)`)
+	)`,
+	`CREATE TABLE IF NOT EXISTS tokens (
+		telegram_id INTEGER PRIMARY KEY,
+		username TEXT NOT NULL,
+		token TEXT NOT NULL,
+		acquired_at INTEGER NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS watches (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		telegram_id INTEGER NOT NULL,
+		chat_id INTEGER NOT NULL,
+		target TEXT NOT NULL,
+		min_idle INTEGER NOT NULL,
+		notified INTEGER NOT NULL DEFAULT 0
+	)`,
+	`CREATE TABLE IF NOT EXISTS snipes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		telegram_id INTEGER NOT NULL,
+		chat_id INTEGER NOT NULL,
+		uuid TEXT NOT NULL,
+		gpus INTEGER NOT NULL,
+		deadline INTEGER NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS refresh_policies (
+		telegram_id INTEGER NOT NULL,
+		chat_id INTEGER NOT NULL,
+		uuid TEXT NOT NULL,
+		days_before INTEGER NOT NULL,
+		PRIMARY KEY (telegram_id, uuid)
+	)`,
+	`CREATE TABLE IF NOT EXISTS settings (
+		telegram_id INTEGER NOT NULL,
+		key TEXT NOT NULL,
+		value TEXT NOT NULL,
+		PRIMARY KEY (telegram_id, key)
+	)`,
+	`CREATE TABLE IF NOT EXISTS release_warnings (
+		telegram_id INTEGER NOT NULL,
+		uuid TEXT NOT NULL,
+		stopped_at TEXT NOT NULL,
+		threshold_days INTEGER NOT NULL,
+		PRIMARY KEY (telegram_id, uuid, stopped_at, threshold_days)
+	)`,
+	`CREATE TABLE IF NOT EXISTS jobs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		telegram_id INTEGER NOT NULL,
+		chat_id INTEGER NOT NULL,
+		kind TEXT NOT NULL,
+		uuid TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		run_at INTEGER NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT '',
+		created_at INTEGER NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS schedules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		telegram_id INTEGER NOT NULL,
+		chat_id INTEGER NOT NULL,
+		uuid TEXT NOT NULL,
+		action TEXT NOT NULL,
+		spec TEXT NOT NULL,
+		next_run INTEGER NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS running_instances (
+		telegram_id INTEGER NOT NULL,
+		uuid TEXT NOT NULL,
+		since INTEGER NOT NULL,
+		reminded_at INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (telegram_id, uuid)
+	)`,
+	`CREATE TABLE IF NOT EXISTS balance_history (
+		telegram_id INTEGER NOT NULL,
+		username TEXT NOT NULL,
+		balance REAL NOT NULL,
+		recorded_at INTEGER NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_balance_history ON balance_history (telegram_id, username, recorded_at)`,
+	`CREATE TABLE IF NOT EXISTS aliases (
+		telegram_id INTEGER NOT NULL,
+		uuid TEXT NOT NULL,
+		name TEXT NOT NULL,
+		PRIMARY KEY (telegram_id, uuid),
+		UNIQUE (telegram_id, name)
+	)`,
+}
 
+// NewUserStorage 打开 DBPath 处的数据库
+func NewUserStorage() (*UserStorage, error) {
+	return OpenUserStorage(DBPath)
+}
+
+// OpenUserStorage 打开path处的数据库，不存在时创建并建表
+func OpenUserStorage(path string) (*UserStorage, error) {
+	db, err := sql.Open("sqlite3", path)
 	if err != nil {
-		db.Close()
 		return nil, err
 	}
+	// SQLite同一时间只允许一个写入，多个goroutine共用一个连接避免 database is locked
+	db.SetMaxOpenConns(1)
+	for _, schema := range schemas {
+		if _, err = db.Exec(schema); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
 	return &UserStorage{db: db}, nil
 }
 
@@ -61,3 +157,490 @@ func (s *UserStorage) LoadUser() (map[int]*models.AutoDLConfig, error) {
 	}
 	return users, nil
 }
+
+// SaveToken 保存用户登录AutoDL后获得的token及获取时间
+func (s *UserStorage) SaveToken(tgID int, username, token string, acquiredAt time.Time) error {
+	_, err := s.db.Exec(
+		"INSERT OR REPLACE INTO tokens (telegram_id, username, token, acquired_at) VALUES (?, ?, ?, ?)",
+		tgID, username, token, acquiredAt.Unix(),
+	)
+	return err
+}
+
+// LoadToken 读取用户保存的token，没有保存时返回空字符串
+func (s *UserStorage) LoadToken(tgID int, username string) (string, time.Time, error) {
+	var token string
+	var acquiredAt int64
+	err := s.db.QueryRow(
+		"SELECT token, acquired_at FROM tokens WHERE telegram_id = ? AND username = ?",
+		tgID, username,
+	).Scan(&token, &acquiredAt)
+	if err == sql.ErrNoRows {
+		return "", time.Time{}, nil
+	}
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, time.Unix(acquiredAt, 0), nil
+}
+
+// DeleteToken 删除用户保存的token，在用户更换账号或密码后调用
+func (s *UserStorage) DeleteToken(tgID int) error {
+	_, err := s.db.Exec("DELETE FROM tokens WHERE telegram_id = ?", tgID)
+	return err
+}
+
+// AddWatch 保存GPU空闲提醒订阅，返回订阅ID
+func (s *UserStorage) AddWatch(w *models.Watch) (int64, error) {
+	result, err := s.db.Exec(
+		"INSERT INTO watches (telegram_id, chat_id, target, min_idle, notified) VALUES (?, ?, ?, ?, ?)",
+		w.TelegramID, w.ChatID, w.Target, w.MinIdle, w.Notified,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// DeleteWatch 删除用户的订阅，target可以是订阅ID或订阅目标，返回删除的数量
+func (s *UserStorage) DeleteWatch(tgID int, target string) (int64, error) {
+	result, err := s.db.Exec(
+		"DELETE FROM watches WHERE telegram_id = ? AND (CAST(id AS TEXT) = ? OR target = ?)",
+		tgID, target, target,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// SetWatchNotified 更新订阅是否已经提醒过
+func (s *UserStorage) SetWatchNotified(id int64, notified bool) error {
+	_, err := s.db.Exec("UPDATE watches SET notified = ? WHERE id = ?", notified, id)
+	return err
+}
+
+// ListWatches 读取用户的订阅，tgID为0时读取所有用户的订阅
+func (s *UserStorage) ListWatches(tgID int) ([]*models.Watch, error) {
+	query := "SELECT id, telegram_id, chat_id, target, min_idle, notified FROM watches"
+	var args []interface{}
+	if tgID != 0 {
+		query += " WHERE telegram_id = ?"
+		args = append(args, tgID)
+	}
+	rows, err := s.db.Query(query+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var watches []*models.Watch
+	for rows.Next() {
+		w := &models.Watch{}
+		if err := rows.Scan(&w.ID, &w.TelegramID, &w.ChatID, &w.Target, &w.MinIdle, &w.Notified); err != nil {
+			return nil, err
+		}
+		watches = append(watches, w)
+	}
+	return watches, rows.Err()
+}
+
+// AddSnipe 保存自动开机任务，返回任务ID
+func (s *UserStorage) AddSnipe(snipe *models.Snipe) (int64, error) {
+	result, err := s.db.Exec(
+		"INSERT INTO snipes (telegram_id, chat_id, uuid, gpus, deadline) VALUES (?, ?, ?, ?, ?)",
+		snipe.TelegramID, snipe.ChatID, snipe.UUID, snipe.GPUs, snipe.Deadline.Unix(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// DeleteSnipe 删除自动开机任务，tgID不为0时只删除该用户的任务
+func (s *UserStorage) DeleteSnipe(tgID int, id int64) (int64, error) {
+	query := "DELETE FROM snipes WHERE id = ?"
+	args := []interface{}{id}
+	if tgID != 0 {
+		query += " AND telegram_id = ?"
+		args = append(args, tgID)
+	}
+	result, err := s.db.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// ListSnipes 读取用户的自动开机任务，tgID为0时读取所有用户的任务
+func (s *UserStorage) ListSnipes(tgID int) ([]*models.Snipe, error) {
+	query := "SELECT id, telegram_id, chat_id, uuid, gpus, deadline FROM snipes"
+	var args []interface{}
+	if tgID != 0 {
+		query += " WHERE telegram_id = ?"
+		args = append(args, tgID)
+	}
+	rows, err := s.db.Query(query+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var snipes []*models.Snipe
+	for rows.Next() {
+		snipe := &models.Snipe{}
+		var deadline int64
+		if err := rows.Scan(&snipe.ID, &snipe.TelegramID, &snipe.ChatID, &snipe.UUID, &snipe.GPUs, &deadline); err != nil {
+			return nil, err
+		}
+		snipe.Deadline = time.Unix(deadline, 0)
+		snipes = append(snipes, snipe)
+	}
+	return snipes, rows.Err()
+}
+
+// SaveRefreshPolicy 保存或更新实例的自动刷新策略
+func (s *UserStorage) SaveRefreshPolicy(policy *models.RefreshPolicy) error {
+	_, err := s.db.Exec(
+		"INSERT OR REPLACE INTO refresh_policies (telegram_id, chat_id, uuid, days_before) VALUES (?, ?, ?, ?)",
+		policy.TelegramID, policy.ChatID, policy.UUID, policy.DaysBefore,
+	)
+	return err
+}
+
+// DeleteRefreshPolicy 删除实例的自动刷新策略，返回删除的数量
+func (s *UserStorage) DeleteRefreshPolicy(tgID int, uuid string) (int64, error) {
+	result, err := s.db.Exec("DELETE FROM refresh_policies WHERE telegram_id = ? AND uuid = ?", tgID, uuid)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// ListRefreshPolicies 读取用户的自动刷新策略，tgID为0时读取所有用户的策略
+func (s *UserStorage) ListRefreshPolicies(tgID int) ([]*models.RefreshPolicy, error) {
+	query := "SELECT telegram_id, chat_id, uuid, days_before FROM refresh_policies"
+	var args []interface{}
+	if tgID != 0 {
+		query += " WHERE telegram_id = ?"
+		args = append(args, tgID)
+	}
+	rows, err := s.db.Query(query+" ORDER BY telegram_id, uuid", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var policies []*models.RefreshPolicy
+	for rows.Next() {
+		policy := &models.RefreshPolicy{}
+		if err := rows.Scan(&policy.TelegramID, &policy.ChatID, &policy.UUID, &policy.DaysBefore); err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+	return policies, rows.Err()
+}
+
+// GetSetting 读取用户的设置项，未设置时返回false
+func (s *UserStorage) GetSetting(tgID int, key string) (string, bool, error) {
+	var value string
+	err := s.db.QueryRow(
+		"SELECT value FROM settings WHERE telegram_id = ? AND key = ?", tgID, key,
+	).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+// SetSetting 保存用户的设置项
+func (s *UserStorage) SetSetting(tgID int, key, value string) error {
+	_, err := s.db.Exec(
+		"INSERT OR REPLACE INTO settings (telegram_id, key, value) VALUES (?, ?, ?)",
+		tgID, key, value,
+	)
+	return err
+}
+
+// DeleteSetting 删除用户的设置项，恢复默认值
+func (s *UserStorage) DeleteSetting(tgID int, key string) error {
+	_, err := s.db.Exec("DELETE FROM settings WHERE telegram_id = ? AND key = ?", tgID, key)
+	return err
+}
+
+// MarkReleaseWarning 记录已针对实例某次关机发送过的释放提醒，已记录时返回false
+func (s *UserStorage) MarkReleaseWarning(tgID int, uuid, stoppedAt string, thresholdDays int) (bool, error) {
+	result, err := s.db.Exec(
+		"INSERT OR IGNORE INTO release_warnings (telegram_id, uuid, stopped_at, threshold_days) VALUES (?, ?, ?, ?)",
+		tgID, uuid, stoppedAt, thresholdDays,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// HasReleaseWarning 判断是否已针对实例某次关机记录过指定阈值的释放提醒
+func (s *UserStorage) HasReleaseWarning(tgID int, uuid, stoppedAt string, thresholdDays int) (bool, error) {
+	var n int
+	err := s.db.QueryRow(
+		"SELECT COUNT(*) FROM release_warnings WHERE telegram_id = ? AND uuid = ? AND stopped_at = ? AND threshold_days = ?",
+		tgID, uuid, stoppedAt, thresholdDays,
+	).Scan(&n)
+	return n > 0, err
+}
+
+const jobColumns = "id, telegram_id, chat_id, kind, uuid, payload, run_at, attempts, last_error, created_at"
+
+// AddJob 保存后台任务，返回任务ID
+func (s *UserStorage) AddJob(job *models.Job) (int64, error) {
+	result, err := s.db.Exec(
+		"INSERT INTO jobs (telegram_id, chat_id, kind, uuid, payload, run_at, attempts, last_error, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		job.TelegramID, job.ChatID, job.Kind, job.UUID, job.Payload,
+		job.RunAt.Unix(), job.Attempts, job.LastError, job.CreatedAt.Unix(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// UpdateJob 更新任务的执行时间和重试信息
+func (s *UserStorage) UpdateJob(job *models.Job) error {
+	_, err := s.db.Exec(
+		"UPDATE jobs SET run_at = ?, attempts = ?, last_error = ?, payload = ? WHERE id = ?",
+		job.RunAt.Unix(), job.Attempts, job.LastError, job.Payload, job.ID,
+	)
+	return err
+}
+
+// DeleteJob 删除已完成的任务
+func (s *UserStorage) DeleteJob(id int64) error {
+	_, err := s.db.Exec("DELETE FROM jobs WHERE id = ?", id)
+	return err
+}
+
+// CancelJob 删除用户的任务，任务不存在或不属于该用户时返回false
+func (s *UserStorage) CancelJob(tgID int, id int64) (bool, error) {
+	result, err := s.db.Exec("DELETE FROM jobs WHERE id = ? AND telegram_id = ?", id, tgID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// DueJobs 读取执行时间已到的任务
+func (s *UserStorage) DueJobs(now time.Time) ([]*models.Job, error) {
+	return s.queryJobs("SELECT "+jobColumns+" FROM jobs WHERE run_at <= ? ORDER BY run_at, id", now.Unix())
+}
+
+// ListJobs 读取用户尚未完成的任务
+func (s *UserStorage) ListJobs(tgID int) ([]*models.Job, error) {
+	return s.queryJobs("SELECT "+jobColumns+" FROM jobs WHERE telegram_id = ? ORDER BY run_at, id", tgID)
+}
+
+func (s *UserStorage) queryJobs(query string, args ...interface{}) ([]*models.Job, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []*models.Job
+	for rows.Next() {
+		job := &models.Job{}
+		var runAt, createdAt int64
+		err := rows.Scan(&job.ID, &job.TelegramID, &job.ChatID, &job.Kind, &job.UUID, &job.Payload,
+			&runAt, &job.Attempts, &job.LastError, &createdAt)
+		if err != nil {
+			return nil, err
+		}
+		job.RunAt = time.Unix(runAt, 0)
+		job.CreatedAt = time.Unix(createdAt, 0)
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// AddSchedule 保存定时开关机计划，返回计划ID
+func (s *UserStorage) AddSchedule(schedule *models.Schedule) (int64, error) {
+	result, err := s.db.Exec(
+		"INSERT INTO schedules (telegram_id, chat_id, uuid, action, spec, next_run) VALUES (?, ?, ?, ?, ?, ?)",
+		schedule.TelegramID, schedule.ChatID, schedule.UUID, schedule.Action, schedule.Spec, schedule.NextRun.Unix(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// DeleteSchedule 删除用户的定时开关机计划，返回删除的行数
+func (s *UserStorage) DeleteSchedule(tgID int, id int64) (int64, error) {
+	result, err := s.db.Exec("DELETE FROM schedules WHERE id = ? AND telegram_id = ?", id, tgID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// SetScheduleNextRun 更新计划的下次执行时间
+func (s *UserStorage) SetScheduleNextRun(id int64, next time.Time) error {
+	_, err := s.db.Exec("UPDATE schedules SET next_run = ? WHERE id = ?", next.Unix(), id)
+	return err
+}
+
+// ListSchedules 读取用户的定时开关机计划，tgID为0时读取所有用户的计划
+func (s *UserStorage) ListSchedules(tgID int) ([]*models.Schedule, error) {
+	query := "SELECT id, telegram_id, chat_id, uuid, action, spec, next_run FROM schedules"
+	var args []interface{}
+	if tgID != 0 {
+		query += " WHERE telegram_id = ?"
+		args = append(args, tgID)
+	}
+	rows, err := s.db.Query(query+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var schedules []*models.Schedule
+	for rows.Next() {
+		schedule := &models.Schedule{}
+		var nextRun int64
+		if err := rows.Scan(&schedule.ID, &schedule.TelegramID, &schedule.ChatID, &schedule.UUID,
+			&schedule.Action, &schedule.Spec, &nextRun); err != nil {
+			return nil, err
+		}
+		schedule.NextRun = time.Unix(nextRun, 0)
+		schedules = append(schedules, schedule)
+	}
+	return schedules, rows.Err()
+}
+
+// SaveRunningInstance 保存或更新实例的运行记录
+func (s *UserStorage) SaveRunningInstance(running *models.RunningInstance) error {
+	var remindedAt int64
+	if !running.RemindedAt.IsZero() {
+		remindedAt = running.RemindedAt.Unix()
+	}
+	_, err := s.db.Exec(
+		"INSERT OR REPLACE INTO running_instances (telegram_id, uuid, since, reminded_at) VALUES (?, ?, ?, ?)",
+		running.TelegramID, running.UUID, running.Since.Unix(), remindedAt,
+	)
+	return err
+}
+
+// DeleteRunningInstance 删除已不在运行的实例的运行记录
+func (s *UserStorage) DeleteRunningInstance(tgID int, uuid string) error {
+	_, err := s.db.Exec("DELETE FROM running_instances WHERE telegram_id = ? AND uuid = ?", tgID, uuid)
+	return err
+}
+
+// ListRunningInstances 读取用户所有实例的运行记录
+func (s *UserStorage) ListRunningInstances(tgID int) ([]*models.RunningInstance, error) {
+	rows, err := s.db.Query("SELECT telegram_id, uuid, since, reminded_at FROM running_instances WHERE telegram_id = ?", tgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var instances []*models.RunningInstance
+	for rows.Next() {
+		running := &models.RunningInstance{}
+		var since, remindedAt int64
+		if err := rows.Scan(&running.TelegramID, &running.UUID, &since, &remindedAt); err != nil {
+			return nil, err
+		}
+		running.Since = time.Unix(since, 0)
+		if remindedAt != 0 {
+			running.RemindedAt = time.Unix(remindedAt, 0)
+		}
+		instances = append(instances, running)
+	}
+	return instances, rows.Err()
+}
+
+// AddBalance 记录AutoDL账号在某一时刻的余额
+func (s *UserStorage) AddBalance(tgID int, username string, balance float64, recordedAt time.Time) error {
+	_, err := s.db.Exec(
+		"INSERT INTO balance_history (telegram_id, username, balance, recorded_at) VALUES (?, ?, ?, ?)",
+		tgID, username, balance, recordedAt.Unix(),
+	)
+	return err
+}
+
+// ListBalances 按时间顺序读取账号since之后的余额记录，并包含since之前的最后一条记录作为起点
+func (s *UserStorage) ListBalances(tgID int, username string, since time.Time) ([]models.BalanceRecord, error) {
+	rows, err := s.db.Query(`SELECT balance, recorded_at FROM balance_history
+		WHERE telegram_id = ? AND username = ? AND recorded_at >= (
+			SELECT COALESCE(MAX(recorded_at), 0) FROM balance_history
+			WHERE telegram_id = ? AND username = ? AND recorded_at <= ?
+		) ORDER BY recorded_at`,
+		tgID, username, tgID, username, since.Unix(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []models.BalanceRecord
+	for rows.Next() {
+		var record models.BalanceRecord
+		var recordedAt int64
+		if err := rows.Scan(&record.Balance, &recordedAt); err != nil {
+			return nil, err
+		}
+		record.RecordedAt = time.Unix(recordedAt, 0)
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// PruneBalances 删除before之前的余额记录
+func (s *UserStorage) PruneBalances(before time.Time) error {
+	_, err := s.db.Exec("DELETE FROM balance_history WHERE recorded_at < ?", before.Unix())
+	return err
+}
+
+// SetAlias 设置实例的别名，已有别名时覆盖
+func (s *UserStorage) SetAlias(tgID int, uuid, name string) error {
+	_, err := s.db.Exec(
+		"INSERT OR REPLACE INTO aliases (telegram_id, uuid, name) VALUES (?, ?, ?)",
+		tgID, uuid, name,
+	)
+	return err
+}
+
+// DeleteAlias 删除实例的别名，返回删除的行数
+func (s *UserStorage) DeleteAlias(tgID int, uuid string) (int64, error) {
+	result, err := s.db.Exec("DELETE FROM aliases WHERE telegram_id = ? AND uuid = ?", tgID, uuid)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// ListAliases 读取用户的实例别名，返回UUID到别名的映射
+func (s *UserStorage) ListAliases(tgID int) (map[string]string, error) {
+	rows, err := s.db.Query("SELECT uuid, name FROM aliases WHERE telegram_id = ?", tgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	aliases := make(map[string]string)
+	for rows.Next() {
+		var uuid, name string
+		if err := rows.Scan(&uuid, &name); err != nil {
+			return nil, err
+		}
+		aliases[uuid] = name
+	}
+	return aliases, rows.Err()
+}